@@ -0,0 +1,112 @@
+package awsutils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssumeRole is a role assumed with STS, using the credentials of the service key or of the previous role in a
+// chain. The role credentials are refreshed automatically before they expire.
+type AssumeRole struct {
+	RoleArn       string                 `json:"roleArn"`
+	ExternalId    string                 `json:"externalId"`
+	SessionName   string                 `json:"sessionName"`  // Generated by the SDK when empty
+	Duration      time.Duration          `json:"duration"`     // 15 minutes when zero
+	SerialNumber  string                 `json:"serialNumber"` // MFA device; requires TokenProvider
+	TokenProvider func() (string, error) `json:"-"`            // Returns the current MFA token code
+}
+
+// RoleChainCacheTTL is how long the credentials of a role chain are shared by the configs created for it before a
+// new config assumes the roles again
+var RoleChainCacheTTL = time.Hour
+
+type cachedRoleChainCredentials struct {
+	provider aws.CredentialsProvider
+	expiry   time.Time
+}
+
+// roleChainCredentials holds the credentials of each role chain, so that every config created for it shares the
+// same credentials cache instead of assuming the roles again
+var roleChainCredentials struct {
+	mutex   sync.Mutex
+	entries map[[sha256.Size]byte]cachedRoleChainCredentials
+}
+
+// roleChainCredentialsProvider returns the credentials of the last role of the chain, assumed starting from the
+// credentials of baseConfig. baseCredentials identifies those credentials, and retryPolicy the retries of the STS
+// clients, in the cache.
+func roleChainCredentialsProvider(baseConfig aws.Config, baseCredentials string, retryPolicy *RetryPolicy, roleChain []AssumeRole) aws.CredentialsProvider {
+	cacheKey, cacheable := roleChainCacheKey(baseCredentials, retryPolicy, roleChain)
+	if !cacheable {
+		return assumeRoleChain(baseConfig, roleChain)
+	}
+
+	roleChainCredentials.mutex.Lock()
+	defer roleChainCredentials.mutex.Unlock()
+	if cached, defined := roleChainCredentials.entries[cacheKey]; defined && time.Now().Before(cached.expiry) {
+		return cached.provider
+	}
+
+	provider := assumeRoleChain(baseConfig, roleChain)
+	if roleChainCredentials.entries == nil {
+		roleChainCredentials.entries = make(map[[sha256.Size]byte]cachedRoleChainCredentials)
+	}
+	for key, cached := range roleChainCredentials.entries {
+		if !time.Now().Before(cached.expiry) {
+			delete(roleChainCredentials.entries, key)
+		}
+	}
+	roleChainCredentials.entries[cacheKey] = cachedRoleChainCredentials{
+		provider: provider,
+		expiry:   time.Now().Add(RoleChainCacheTTL),
+	}
+	return provider
+}
+
+// roleChainCacheKey hashes what the credentials of a role chain are created from, so that the cache does not hold
+// secrets in plain text. Chains with an MFA token provider are not cached, since providers cannot be compared and
+// one caller's provider would otherwise be used for every other caller.
+func roleChainCacheKey(baseCredentials string, retryPolicy *RetryPolicy, roleChain []AssumeRole) ([sha256.Size]byte, bool) {
+	encodedRetryPolicy, _ := json.Marshal(retryPolicy)
+	cacheKey := baseCredentials + "\n" + string(encodedRetryPolicy)
+	for _, role := range roleChain {
+		if role.TokenProvider != nil {
+			return [sha256.Size]byte{}, false
+		}
+		cacheKey += "\n" + strings.Join([]string{role.RoleArn, role.ExternalId, role.SessionName,
+			strconv.FormatInt(int64(role.Duration), 10), role.SerialNumber}, "|")
+	}
+	return sha256.Sum256([]byte(cacheKey)), true
+}
+
+// assumeRoleChain returns a credentials cache that assumes each role of the chain in turn when it is first used
+func assumeRoleChain(baseConfig aws.Config, roleChain []AssumeRole) aws.CredentialsProvider {
+	awsConfig := baseConfig.Copy()
+	for _, role := range roleChain {
+		role := role
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), role.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+			if role.ExternalId != "" {
+				o.ExternalID = aws.String(role.ExternalId)
+			}
+			if role.SessionName != "" {
+				o.RoleSessionName = role.SessionName
+			}
+			if role.Duration > 0 {
+				o.Duration = role.Duration
+			}
+			if role.SerialNumber != "" {
+				o.SerialNumber = aws.String(role.SerialNumber)
+				o.TokenProvider = role.TokenProvider
+			}
+		})
+		awsConfig.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return awsConfig.Credentials
+}
@@ -0,0 +1,89 @@
+package awsutils
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestRoleChainCacheKey(t *testing.T) {
+	baseCredentials := "us-east-1:AKIAEXAMPLEKEYID:examplesecret"
+	roleChain := []AssumeRole{{RoleArn: "arn:aws:iam::123456789012:role/first"}, {RoleArn: "arn:aws:iam::123456789012:role/second"}}
+	baseKey, cacheable := roleChainCacheKey(baseCredentials, nil, roleChain)
+	if !cacheable {
+		log.Println("expected a chain without a token provider to be cached")
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		name            string
+		baseCredentials string
+		retryPolicy     *RetryPolicy
+		roleChain       []AssumeRole
+		sameKey         bool
+		cacheable       bool
+	}{
+		{"same chain", baseCredentials, nil, []AssumeRole{roleChain[0], roleChain[1]}, true, true},
+		{"other secret", "us-east-1:AKIAEXAMPLEKEYID:othersecret", nil, roleChain, false, true},
+		{"other region", "eu-west-1:AKIAEXAMPLEKEYID:examplesecret", nil, roleChain, false, true},
+		{"retry policy", baseCredentials, &RetryPolicy{MaxAttempts: 10}, roleChain, false, true},
+		{"other role", baseCredentials, nil, []AssumeRole{roleChain[0], {RoleArn: "arn:aws:iam::123456789012:role/other"}}, false, true},
+		{"role order", baseCredentials, nil, []AssumeRole{roleChain[1], roleChain[0]}, false, true},
+		{"external id", baseCredentials, nil, []AssumeRole{roleChain[0], {RoleArn: roleChain[1].RoleArn, ExternalId: "id"}}, false, true},
+		{"duration", baseCredentials, nil, []AssumeRole{roleChain[0], {RoleArn: roleChain[1].RoleArn, Duration: time.Hour}}, false, true},
+		{"token provider", baseCredentials, nil, []AssumeRole{roleChain[0], {RoleArn: roleChain[1].RoleArn, SerialNumber: "mfa",
+			TokenProvider: func() (string, error) { return "123456", nil }}}, false, false},
+	}
+	for _, testCase := range testCases {
+		key, cacheable := roleChainCacheKey(testCase.baseCredentials, testCase.retryPolicy, testCase.roleChain)
+		if cacheable != testCase.cacheable || (cacheable && (key == baseKey) != testCase.sameKey) {
+			log.Println(testCase.name, "expected cacheable", testCase.cacheable, "and same key", testCase.sameKey, "got", cacheable, key == baseKey)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRoleChainCredentialsCache(t *testing.T) {
+	defer func(ttl time.Duration) {
+		RoleChainCacheTTL = ttl
+	}(RoleChainCacheTTL)
+	baseConfig := aws.Config{Region: "us-east-1"}
+	roleChain := []AssumeRole{{RoleArn: "arn:aws:iam::123456789012:role/cached"}}
+	roleChainCredentials.mutex.Lock()
+	roleChainCredentials.entries = nil
+	roleChainCredentials.mutex.Unlock()
+
+	// Entries that expired are replaced, and removed when any other entry is added
+	RoleChainCacheTTL = 0
+	expired := roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:expiredsecret", nil, roleChain)
+	if roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:expiredsecret", nil, roleChain) == expired {
+		log.Println("expected expired credentials to be replaced")
+		t.FailNow()
+	}
+	roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:othersecret", nil, roleChain)
+	roleChainCredentials.mutex.Lock()
+	entries := len(roleChainCredentials.entries)
+	roleChainCredentials.mutex.Unlock()
+	if entries != 1 {
+		log.Println("expected expired entries to be removed, got", entries)
+		t.FailNow()
+	}
+	RoleChainCacheTTL = time.Hour
+
+	first := roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:cachedsecret", nil, roleChain)
+	second := roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:cachedsecret", nil, roleChain)
+	if first != second {
+		log.Println("expected the credentials of the chain to be shared")
+		t.FailNow()
+	}
+
+	mfaChain := []AssumeRole{{RoleArn: "arn:aws:iam::123456789012:role/mfa", SerialNumber: "mfa",
+		TokenProvider: func() (string, error) { return "123456", nil }}}
+	first = roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:cachedsecret", nil, mfaChain)
+	second = roleChainCredentialsProvider(baseConfig, "us-east-1:AKIAEXAMPLEKEYID:cachedsecret", nil, mfaChain)
+	if first == second {
+		log.Println("expected a chain with a token provider not to be cached")
+		t.FailNow()
+	}
+}
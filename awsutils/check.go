@@ -0,0 +1,256 @@
+package awsutils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"net/http"
+	"strings"
+)
+
+const (
+	CheckActionGetCallerIdentity = "sts:GetCallerIdentity"
+	CheckActionHeadBucket        = "s3:HeadBucket"
+	CheckActionListObjectsV2     = "s3:ListObjectsV2"
+	CheckActionPutObject         = "s3:PutObject" // Writes a temporary object and deletes it again
+	CheckActionDeleteObject      = "s3:DeleteObject"
+	CheckActionDescribeClusters  = "ecs:DescribeClusters"
+)
+
+const (
+	CheckStatusAllowed            = "allowed"
+	CheckStatusDenied             = "denied"
+	CheckStatusInvalidCredentials = "invalidCredentials" // The key is unknown or its secret is wrong
+	CheckStatusExpiredCredentials = "expiredCredentials"
+	CheckStatusNotFound           = "notFound"
+	CheckStatusError              = "error"
+)
+
+// DefaultCheckActions are probed when CheckOptions.Actions is empty. DeleteObject is always probed after a
+// successful PutObject to remove the temporary object.
+var DefaultCheckActions = []string{
+	CheckActionHeadBucket,
+	CheckActionListObjectsV2,
+	CheckActionPutObject,
+	CheckActionDescribeClusters,
+}
+
+type CheckOptions struct {
+	Buckets          []string `json:"buckets"`
+	Clusters         []string `json:"clusters"`
+	Actions          []string `json:"actions"`          // S3 and ECS actions to probe, DefaultCheckActions when empty
+	TemporaryKeyPath string   `json:"temporaryKeyPath"` // Prefix of the temporary object written by the PutObject probe
+
+	RetryPolicy *RetryPolicy `json:"-"`
+	RoleChain   []AssumeRole `json:"-"`
+}
+
+type CheckResult struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource,omitempty"`
+	Status   string `json:"status"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type CheckReport struct {
+	Account string        `json:"account"`
+	Arn     string        `json:"arn"`
+	UserId  string        `json:"userId"`
+	Results []CheckResult `json:"results"`
+	Allowed int           `json:"allowed"`
+	Failed  int           `json:"failed"` // Results with any status other than allowed
+}
+
+// Check reports which of the probed actions the service key is allowed to perform, first confirming the identity
+// of the key with GetCallerIdentity. The returned error is only set when the service key cannot be parsed; failed
+// probes are reported in the results.
+func Check(serviceKey string, options CheckOptions) (CheckReport, error) {
+	awsConfig, err := NewAWSConfigWithRoleChain(serviceKey, options.RetryPolicy, options.RoleChain)
+	if err != nil {
+		return CheckReport{}, err
+	}
+
+	var report CheckReport
+	identity, err := sts.NewFromConfig(awsConfig).GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+	report.add(CheckActionGetCallerIdentity, "", err)
+	if err != nil {
+		// Every other probe would fail the same way
+		return report, nil
+	}
+	report.Account = aws.ToString(identity.Account)
+	report.Arn = aws.ToString(identity.Arn)
+	report.UserId = aws.ToString(identity.UserId)
+
+	actions := options.Actions
+	if len(actions) == 0 {
+		actions = DefaultCheckActions
+	}
+	probes := make(map[string]bool)
+	for _, action := range actions {
+		probes[action] = true
+	}
+
+	s3Client := s3.NewFromConfig(awsConfig)
+	for _, bucket := range options.Buckets {
+		if !probes[CheckActionHeadBucket] && !probes[CheckActionListObjectsV2] && !probes[CheckActionPutObject] {
+			break
+		}
+
+		// Requests for a bucket have to be sent to its region. The lookup is signed, since buckets that deny
+		// anonymous requests would otherwise report AccessDenied for keys that may access them.
+		region, err := manager.GetBucketRegion(context.Background(), s3Client, bucket, func(o *s3.Options) {
+			o.Credentials = awsConfig.Credentials
+		})
+		if err != nil {
+			report.add(CheckActionHeadBucket, bucket, err)
+			continue
+		}
+		if region == "" {
+			region = awsConfig.Region
+		}
+		bucketClient := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			o.Region = region
+		})
+		report.checkBucket(bucketClient, bucket, probes, options.TemporaryKeyPath)
+	}
+
+	if probes[CheckActionDescribeClusters] && len(options.Clusters) > 0 {
+		report.checkClusters(ecs.NewFromConfig(awsConfig), options.Clusters)
+	}
+
+	return report, nil
+}
+
+func (r *CheckReport) checkBucket(s3Client *s3.Client, bucket string, probes map[string]bool, temporaryKeyPath string) {
+	if probes[CheckActionHeadBucket] {
+		_, err := s3Client.HeadBucket(context.Background(), &s3.HeadBucketInput{
+			Bucket: aws.String(bucket),
+		})
+		r.add(CheckActionHeadBucket, bucket, err)
+	}
+
+	if probes[CheckActionListObjectsV2] {
+		_, err := s3Client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+			Bucket:  aws.String(bucket),
+			MaxKeys: aws.Int32(1),
+		})
+		r.add(CheckActionListObjectsV2, bucket, err)
+	}
+
+	if probes[CheckActionPutObject] {
+		suffix := make([]byte, 8)
+		_, _ = rand.Read(suffix)
+		key := temporaryKeyPath + ".awsutils-check-" + hex.EncodeToString(suffix)
+		_, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(""),
+		})
+		r.add(CheckActionPutObject, bucket+"/"+key, err)
+		if err != nil {
+			return
+		}
+
+		_, err = s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		r.add(CheckActionDeleteObject, bucket+"/"+key, err)
+	}
+}
+
+func (r *CheckReport) checkClusters(ecsClient *ecs.Client, clusters []string) {
+	output, err := ecsClient.DescribeClusters(context.Background(), &ecs.DescribeClustersInput{
+		Clusters: clusters,
+	})
+	if err != nil {
+		for _, cluster := range clusters {
+			r.add(CheckActionDescribeClusters, cluster, err)
+		}
+		return
+	}
+
+	// Clusters that do not exist are reported as failures rather than an error
+	missing := make(map[string]string)
+	for _, failure := range output.Failures {
+		missing[aws.ToString(failure.Arn)] = aws.ToString(failure.Reason)
+	}
+	for _, cluster := range clusters {
+		reason, isMissing := missing[cluster]
+		if !isMissing {
+			for arn, arnReason := range missing {
+				if strings.HasSuffix(arn, "/"+cluster) {
+					reason, isMissing = arnReason, true
+				}
+			}
+		}
+		if isMissing {
+			r.Results = append(r.Results, CheckResult{
+				Action:   CheckActionDescribeClusters,
+				Resource: cluster,
+				Status:   CheckStatusNotFound,
+				Code:     reason,
+			})
+			r.Failed++
+			continue
+		}
+		r.add(CheckActionDescribeClusters, cluster, nil)
+	}
+}
+
+func (r *CheckReport) add(action string, resource string, err error) {
+	result := CheckResult{
+		Action:   action,
+		Resource: resource,
+		Status:   CheckStatusAllowed,
+	}
+	if err != nil {
+		result.Status, result.Code = checkStatus(err)
+		result.Message = err.Error()
+		r.Failed++
+	} else {
+		r.Allowed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// checkStatus classifies a failed probe by its error code, falling back to the HTTP status for responses without a
+// body such as HeadBucket
+func checkStatus(err error) (string, string) {
+	var code string
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		code = apiError.ErrorCode()
+	}
+
+	switch code {
+	case "InvalidClientTokenId", "InvalidAccessKeyId", "SignatureDoesNotMatch", "UnrecognizedClientException":
+		return CheckStatusInvalidCredentials, code
+	case "ExpiredToken", "ExpiredTokenException", "RequestExpired", "TokenRefreshRequired":
+		return CheckStatusExpiredCredentials, code
+	case "AccessDenied", "AccessDeniedException", "Forbidden", "AllAccessDisabled":
+		return CheckStatusDenied, code
+	case "NoSuchBucket", "NotFound", "ClusterNotFoundException":
+		return CheckStatusNotFound, code
+	}
+
+	var responseError *smithyhttp.ResponseError
+	if errors.As(err, &responseError) {
+		switch responseError.HTTPStatusCode() {
+		case http.StatusForbidden:
+			return CheckStatusDenied, code
+		case http.StatusNotFound:
+			return CheckStatusNotFound, code
+		}
+	}
+	return CheckStatusError, code
+}
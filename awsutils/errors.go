@@ -0,0 +1,25 @@
+package awsutils
+
+import "errors"
+
+var ErrInvalidServiceKey = errors.New("invalid service key")
+
+// ServiceKeyError describes why a service key could not be used. It matches ErrInvalidServiceKey with errors.Is.
+// Err holds the underlying error, such as the API error of a failed SSM or Secrets Manager request, when there is
+// one.
+type ServiceKeyError struct {
+	Reason string `json:"reason"`
+	Err    error  `json:"-"`
+}
+
+func (e *ServiceKeyError) Error() string {
+	return e.Reason
+}
+
+func (e *ServiceKeyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ServiceKeyError) Is(target error) bool {
+	return target == ErrInvalidServiceKey
+}
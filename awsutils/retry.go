@@ -0,0 +1,98 @@
+package awsutils
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Requests are retried with exponential backoff and full
+// jitter when the error is one the SDK considers retryable (throttling, 5xx responses and connection errors) or its
+// code is in RetryableCodes. Zero fields take their value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts    int           `json:"maxAttempts"` // Including the first attempt
+	BaseDelay      time.Duration `json:"baseDelay"`
+	MaxDelay       time.Duration `json:"maxDelay"`
+	RetryableCodes []string      `json:"retryableCodes"`
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    20 * time.Second,
+	RetryableCodes: []string{
+		"SlowDown",
+		"ServiceUnavailable",
+		"InternalError",
+		"RequestTimeout",
+		"ThrottlingException",
+		"Throttling",
+		"RequestLimitExceeded",
+	},
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.RetryableCodes == nil {
+		p.RetryableCodes = DefaultRetryPolicy.RetryableCodes
+	}
+	return p
+}
+
+// Backoff returns the delay before the given retry, counting from 0 for the first retry. The delay is chosen at
+// random between zero and BaseDelay doubled for each retry, capped at MaxDelay.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	p = p.withDefaults()
+
+	delay := p.MaxDelay
+	if retry < 63 && p.BaseDelay < p.MaxDelay>>uint(retry) {
+		delay = p.BaseDelay << uint(retry)
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// IsRetryableCode reports whether requests failing with the error code are retried in addition to those the SDK
+// retries by default
+func (p RetryPolicy) IsRetryableCode(code string) bool {
+	for _, retryableCode := range p.withDefaults().RetryableCodes {
+		if code == retryableCode {
+			return true
+		}
+	}
+	return false
+}
+
+// newRetryer creates an SDK standard retryer for the policy. Retries are not limited by the SDK retry quota, since
+// bulk operations may be throttled for longer than the quota allows and back off through the policy instead.
+func (p RetryPolicy) newRetryer() aws.Retryer {
+	retryableCodes := make(map[string]struct{})
+	for _, code := range p.RetryableCodes {
+		retryableCodes[code] = struct{}{}
+	}
+
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = p.MaxAttempts
+		o.Backoff = retryBackoff{policy: p}
+		o.Retryables = append([]retry.IsErrorRetryable{retry.RetryableErrorCode{Codes: retryableCodes}}, o.Retryables...)
+		o.RateLimiter = ratelimit.None
+	})
+}
+
+// retryBackoff adapts RetryPolicy.Backoff to the SDK retry.BackoffDelayer interface, which counts attempts from 1
+type retryBackoff struct {
+	policy RetryPolicy
+}
+
+func (b retryBackoff) BackoffDelay(attempt int, err error) (time.Duration, error) {
+	return b.policy.Backoff(attempt - 1), nil
+}
@@ -1,31 +1,127 @@
 package awsutils
 
 import (
-	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"strings"
 )
 
-func NewAWSSession(serviceKey string) (*session.Session, error) {
+// NewAWSConfig creates a config from a service key in the legacy <AWS Region>:<Access Key ID>:<Access Key Secret>
+// format, encrypted with EncryptServiceKey, or given as a reference resolved by ResolveServiceKey
+func NewAWSConfig(serviceKey string) (aws.Config, error) {
+	return NewAWSConfigWithRetryPolicy(serviceKey, nil)
+}
+
+// NewAWSConfigWithRetryPolicy creates a config whose clients retry failed requests according to the policy. A nil
+// policy keeps the SDK default retries.
+func NewAWSConfigWithRetryPolicy(serviceKey string, retryPolicy *RetryPolicy) (aws.Config, error) {
+	return NewAWSConfigWithRoleChain(serviceKey, retryPolicy, nil)
+}
+
+// NewAWSConfigWithRoleChain creates a config with the credentials of the last role in the chain, assumed in order
+// starting from the service key. An empty chain uses the service key credentials.
+func NewAWSConfigWithRoleChain(serviceKey string, retryPolicy *RetryPolicy, roleChain []AssumeRole) (aws.Config, error) {
+	region, keyId, keySecret, err := parseServiceKey(serviceKey)
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	optFns := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(keyId, keySecret, "")),
+	}
+	if retryPolicy != nil {
+		optFns = append(optFns, config.WithRetryer(retryPolicy.withDefaults().newRetryer))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), optFns...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if len(roleChain) > 0 {
+		awsConfig.Credentials = roleChainCredentialsProvider(awsConfig, region+":"+keyId+":"+keySecret, retryPolicy, roleChain)
+	}
+
+	return awsConfig, nil
+}
+
+// parseServiceKey splits a service key into its parts, resolving references and decrypting it with the configured
+// master key if needed
+func parseServiceKey(serviceKey string) (string, string, string, error) {
+	serviceKey, err := ResolveServiceKey(serviceKey)
+	if err != nil {
+		return "", "", "", err
+	}
+	if !IsEncryptedServiceKey(serviceKey) {
+		return parseLegacyServiceKey(serviceKey)
+	}
+
+	masterKey, err := configuredMasterKey()
+	if err != nil {
+		return "", "", "", err
+	}
+	serviceKey, err = DecryptServiceKey(serviceKey, masterKey)
+	if err != nil {
+		return "", "", "", err
+	}
+	return parseLegacyServiceKey(serviceKey)
+}
+
+func parseLegacyServiceKey(serviceKey string) (string, string, string, error) {
 	if serviceKey == "" {
-		return nil, errors.New("service key cannot be empty")
+		return "", "", "", &ServiceKeyError{Reason: "service key cannot be empty"}
 	}
 	tokens := strings.Split(serviceKey, ":")
 	if len(tokens) != 3 {
-		return nil, errors.New("invalid service key format")
+		return "", "", "", &ServiceKeyError{Reason: "invalid service key format"}
+	}
+
+	return tokens[0], tokens[1], tokens[2], nil
+}
+
+// ServiceKeyRegion returns the region of a service key without decrypting or resolving it. It is empty for a
+// reference without a region prefix.
+func ServiceKeyRegion(serviceKey string) string {
+	if region, _, ok := splitServiceKeyReference(serviceKey); ok {
+		return region
 	}
+	return strings.Split(strings.TrimPrefix(serviceKey, encryptedServiceKeyPrefix), ":")[0]
+}
 
-	region := tokens[0]
+// ServiceKeyWithRegion returns the service key with its region replaced, without decrypting or resolving it
+func ServiceKeyWithRegion(serviceKey string, region string) string {
+	if _, reference, ok := splitServiceKeyReference(serviceKey); ok {
+		return region + ":" + reference
+	}
+
+	prefix := ""
+	if IsEncryptedServiceKey(serviceKey) {
+		prefix = encryptedServiceKeyPrefix
+	}
+	tokens := strings.Split(strings.TrimPrefix(serviceKey, prefix), ":")
+	tokens[0] = region
+	return prefix + strings.Join(tokens, ":")
+}
+
+// RedactServiceKey returns a form of the service key that is safe to log: the region and the first characters of
+// the access key ID of a legacy key, only the region of an encrypted key, and references unchanged
+func RedactServiceKey(serviceKey string) string {
+	if serviceKey == "" || IsServiceKeyReference(serviceKey) {
+		return serviceKey
+	}
+	if IsEncryptedServiceKey(serviceKey) {
+		return encryptedServiceKeyPrefix + ServiceKeyRegion(serviceKey) + ":REDACTED"
+	}
+
+	tokens := strings.Split(serviceKey, ":")
+	if len(tokens) != 3 {
+		return "REDACTED"
+	}
 	keyId := tokens[1]
-	keySecret := tokens[2]
-
-	return session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-		Config: aws.Config{
-			Credentials: credentials.NewStaticCredentials(keyId, keySecret, ""),
-			Region:      aws.String(region),
-		},
-	})), nil
+	if len(keyId) > 4 {
+		keyId = keyId[:4]
+	}
+	return tokens[0] + ":" + keyId + "...:REDACTED"
 }
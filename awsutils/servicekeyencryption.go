@@ -0,0 +1,154 @@
+package awsutils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"strings"
+	"sync"
+)
+
+// encryptedServiceKeyPrefix starts the encrypted service key format:
+//
+//	enc:v1:<AWS Region>:<base64url of nonce and AES-256-GCM ciphertext of "<Access Key ID>:<Access Key Secret>">
+//
+// Only the prefix is authenticated with the credentials, so the ciphertext is bound to the format version and not to
+// the region. The region is left in the clear and unauthenticated so that a key can be localized to the region of a
+// bucket without the master key, as ServiceKeyWithRegion does; changing it only changes where requests are sent.
+const encryptedServiceKeyPrefix = "enc:v1:"
+
+const ServiceKeyMasterKeySize = 32
+
+// ServiceKeyMasterKeyEnv names the environment variable holding the base64 encoded master key used to decrypt
+// service keys when none has been set with SetServiceKeyMasterKey
+const ServiceKeyMasterKeyEnv = "AWSUTILS_SERVICE_KEY_MASTER_KEY"
+
+var serviceKeyMasterKey struct {
+	mutex sync.RWMutex
+	key   []byte
+}
+
+// SetServiceKeyMasterKey sets the master key used to decrypt encrypted service keys, replacing the key from
+// ServiceKeyMasterKeyEnv
+func SetServiceKeyMasterKey(masterKey []byte) error {
+	if len(masterKey) != ServiceKeyMasterKeySize {
+		return &ServiceKeyError{Reason: "invalid master key: master key must be 32 bytes"}
+	}
+
+	serviceKeyMasterKey.mutex.Lock()
+	defer serviceKeyMasterKey.mutex.Unlock()
+	serviceKeyMasterKey.key = append([]byte(nil), masterKey...)
+	return nil
+}
+
+func GenerateServiceKeyMasterKey() ([]byte, error) {
+	masterKey := make([]byte, ServiceKeyMasterKeySize)
+	_, err := rand.Read(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return masterKey, nil
+}
+
+func IsEncryptedServiceKey(serviceKey string) bool {
+	return strings.HasPrefix(serviceKey, encryptedServiceKeyPrefix)
+}
+
+// EncryptServiceKey encrypts a service key in the legacy <AWS Region>:<Access Key ID>:<Access Key Secret> format
+// with the master key
+func EncryptServiceKey(serviceKey string, masterKey []byte) (string, error) {
+	region, keyId, keySecret, err := parseLegacyServiceKey(serviceKey)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newServiceKeyCipher(masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(keyId+":"+keySecret), []byte(encryptedServiceKeyPrefix))
+
+	return encryptedServiceKeyPrefix + region + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptServiceKey returns the legacy format of an encrypted service key
+func DecryptServiceKey(encryptedServiceKey string, masterKey []byte) (string, error) {
+	if !IsEncryptedServiceKey(encryptedServiceKey) {
+		return "", &ServiceKeyError{Reason: "invalid service key format: service key is not encrypted"}
+	}
+	tokens := strings.Split(strings.TrimPrefix(encryptedServiceKey, encryptedServiceKeyPrefix), ":")
+	if len(tokens) != 2 || tokens[0] == "" {
+		return "", &ServiceKeyError{Reason: "invalid service key format"}
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(tokens[1])
+	if err != nil {
+		return "", &ServiceKeyError{Reason: "invalid service key format: " + err.Error()}
+	}
+	aead, err := newServiceKeyCipher(masterKey)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", &ServiceKeyError{Reason: "invalid service key format: ciphertext is too short"}
+	}
+
+	credentials, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(encryptedServiceKeyPrefix))
+	if err != nil {
+		return "", &ServiceKeyError{Reason: "service key cannot be decrypted with the master key"}
+	}
+
+	return tokens[0] + ":" + string(credentials), nil
+}
+
+// RotateServiceKey re-encrypts an encrypted service key with a new master key
+func RotateServiceKey(encryptedServiceKey string, oldMasterKey []byte, newMasterKey []byte) (string, error) {
+	serviceKey, err := DecryptServiceKey(encryptedServiceKey, oldMasterKey)
+	if err != nil {
+		return "", err
+	}
+	return EncryptServiceKey(serviceKey, newMasterKey)
+}
+
+// ValidateServiceKey checks that a service key in either format can be used, decrypting encrypted keys with the
+// configured master key
+func ValidateServiceKey(serviceKey string) error {
+	_, _, _, err := parseServiceKey(serviceKey)
+	return err
+}
+
+func newServiceKeyCipher(masterKey []byte) (cipher.AEAD, error) {
+	if len(masterKey) != ServiceKeyMasterKeySize {
+		return nil, &ServiceKeyError{Reason: "invalid master key: master key must be 32 bytes"}
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// configuredMasterKey returns the key set with SetServiceKeyMasterKey, or the one in ServiceKeyMasterKeyEnv
+func configuredMasterKey() ([]byte, error) {
+	serviceKeyMasterKey.mutex.RLock()
+	defer serviceKeyMasterKey.mutex.RUnlock()
+	if serviceKeyMasterKey.key != nil {
+		return serviceKeyMasterKey.key, nil
+	}
+
+	encodedMasterKey := os.Getenv(ServiceKeyMasterKeyEnv)
+	if encodedMasterKey == "" {
+		return nil, &ServiceKeyError{Reason: "no master key configured for encrypted service key"}
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedMasterKey)
+	if err != nil {
+		return nil, &ServiceKeyError{Reason: "invalid master key in " + ServiceKeyMasterKeyEnv + ": " + err.Error()}
+	}
+	return key, nil
+}
@@ -0,0 +1,200 @@
+package awsutils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Service key references name where a service key is kept instead of containing it:
+//
+//	env:NAME                  the environment variable NAME
+//	file:/path                the contents of a file
+//	ssm:/param/name           an SSM parameter, decrypted if it is a SecureString
+//	secretsmanager:id         the string value of a Secrets Manager secret, by name or ARN
+//
+// The referenced value may be a service key in either the legacy or the encrypted format. A reference can be
+// prefixed with an AWS Region, as in us-west-2:ssm:/param/name, to override the region of the resolved key.
+// SSM parameters and secrets are read with the default AWS credential chain.
+const (
+	ServiceKeySchemeEnv            = "env"
+	ServiceKeySchemeFile           = "file"
+	ServiceKeySchemeSSM            = "ssm"
+	ServiceKeySchemeSecretsManager = "secretsmanager"
+)
+
+// ServiceKeyCacheTTL is how long a resolved service key reference is used before it is resolved again
+var ServiceKeyCacheTTL = 5 * time.Minute
+
+type cachedServiceKey struct {
+	serviceKey string
+	expiry     time.Time
+}
+
+var serviceKeyCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedServiceKey
+}
+
+func IsServiceKeyReference(serviceKey string) bool {
+	_, _, ok := splitServiceKeyReference(serviceKey)
+	return ok
+}
+
+// ResolveServiceKey returns the service key a reference names, from the cache while it is fresh. Service keys that
+// are not references are returned unchanged.
+func ResolveServiceKey(serviceKey string) (string, error) {
+	region, reference, ok := splitServiceKeyReference(serviceKey)
+	if !ok {
+		return serviceKey, nil
+	}
+
+	serviceKeyCache.mutex.Lock()
+	cached, defined := serviceKeyCache.entries[reference]
+	serviceKeyCache.mutex.Unlock()
+	if !defined || time.Now().After(cached.expiry) {
+		var err error
+		cached.serviceKey, err = RefreshServiceKey(reference)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if region != "" {
+		return ServiceKeyWithRegion(cached.serviceKey, region), nil
+	}
+	return cached.serviceKey, nil
+}
+
+// RefreshServiceKey resolves a reference without using the cache and caches the result
+func RefreshServiceKey(serviceKey string) (string, error) {
+	region, reference, ok := splitServiceKeyReference(serviceKey)
+	if !ok {
+		return "", &ServiceKeyError{Reason: "invalid service key reference: unknown scheme"}
+	}
+
+	resolvedServiceKey, err := readServiceKeyReference(reference)
+	if err != nil {
+		return "", err
+	}
+	resolvedServiceKey = strings.TrimSpace(resolvedServiceKey)
+	if resolvedServiceKey == "" {
+		return "", &ServiceKeyError{Reason: "invalid service key reference: " + reference + " is empty"}
+	}
+	if IsServiceKeyReference(resolvedServiceKey) {
+		return "", &ServiceKeyError{Reason: "invalid service key reference: " + reference + " refers to another reference"}
+	}
+
+	serviceKeyCache.mutex.Lock()
+	if serviceKeyCache.entries == nil {
+		serviceKeyCache.entries = make(map[string]cachedServiceKey)
+	}
+	serviceKeyCache.entries[reference] = cachedServiceKey{
+		serviceKey: resolvedServiceKey,
+		expiry:     time.Now().Add(ServiceKeyCacheTTL),
+	}
+	serviceKeyCache.mutex.Unlock()
+
+	if region != "" {
+		return ServiceKeyWithRegion(resolvedServiceKey, region), nil
+	}
+	return resolvedServiceKey, nil
+}
+
+func ClearServiceKeyCache() {
+	serviceKeyCache.mutex.Lock()
+	defer serviceKeyCache.mutex.Unlock()
+
+	serviceKeyCache.entries = nil
+}
+
+// splitServiceKeyReference separates the optional region prefix from a reference
+func splitServiceKeyReference(serviceKey string) (string, string, bool) {
+	tokens := strings.SplitN(serviceKey, ":", 3)
+	if len(tokens) >= 2 && isServiceKeyScheme(tokens[0]) {
+		return "", serviceKey, true
+	}
+	if len(tokens) == 3 && tokens[0] != "" && isServiceKeyScheme(tokens[1]) {
+		return tokens[0], tokens[1] + ":" + tokens[2], true
+	}
+	return "", "", false
+}
+
+func isServiceKeyScheme(scheme string) bool {
+	switch scheme {
+	case ServiceKeySchemeEnv, ServiceKeySchemeFile, ServiceKeySchemeSSM, ServiceKeySchemeSecretsManager:
+		return true
+	}
+	return false
+}
+
+func readServiceKeyReference(reference string) (string, error) {
+	tokens := strings.SplitN(reference, ":", 2)
+	scheme := tokens[0]
+	name := tokens[1]
+	if name == "" {
+		return "", &ServiceKeyError{Reason: "invalid service key reference: missing name in " + reference}
+	}
+
+	switch scheme {
+	case ServiceKeySchemeEnv:
+		value, defined := os.LookupEnv(name)
+		if !defined {
+			return "", &ServiceKeyError{Reason: "invalid service key reference: environment variable " + name + " is not set"}
+		}
+		return value, nil
+	case ServiceKeySchemeFile:
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return "", serviceKeyReferenceError(reference, err)
+		}
+		return string(b), nil
+	case ServiceKeySchemeSSM:
+		awsConfig, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return "", serviceKeyReferenceError(reference, err)
+		}
+		output, err := ssm.NewFromConfig(awsConfig).GetParameter(context.Background(), &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", serviceKeyReferenceError(reference, err)
+		}
+		return aws.ToString(output.Parameter.Value), nil
+	case ServiceKeySchemeSecretsManager:
+		optFns := []func(*config.LoadOptions) error{}
+		// A secret ARN has to be read in the region it is in
+		if arnTokens := strings.Split(name, ":"); len(arnTokens) > 3 && arnTokens[0] == "arn" {
+			optFns = append(optFns, config.WithRegion(arnTokens[3]))
+		}
+		awsConfig, err := config.LoadDefaultConfig(context.Background(), optFns...)
+		if err != nil {
+			return "", serviceKeyReferenceError(reference, err)
+		}
+		output, err := secretsmanager.NewFromConfig(awsConfig).GetSecretValue(context.Background(), &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			return "", serviceKeyReferenceError(reference, err)
+		}
+		return aws.ToString(output.SecretString), nil
+	}
+	return "", &ServiceKeyError{Reason: "invalid service key reference: unknown scheme '" + scheme + "'"}
+}
+
+// serviceKeyReferenceError names only the reference in the reason, since the messages of the sources may quote what
+// was read. The cause is kept for errors.As.
+func serviceKeyReferenceError(reference string, err error) error {
+	return &ServiceKeyError{
+		Reason: "invalid service key reference: error reading " + reference,
+		Err:    err,
+	}
+}
@@ -0,0 +1,21 @@
+package ecsutils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+)
+
+// ECSClient is the subset of the ECS API used by this package. It is implemented by *ecs.Client and by the fake in
+// the ecsfake package, so that tests can inject a client instead of building one from a service key.
+type ECSClient interface {
+	RunTask(ctx context.Context, params *ecs.RunTaskInput, optFns ...func(*ecs.Options)) (*ecs.RunTaskOutput, error)
+}
+
+var _ ECSClient = (*ecs.Client)(nil)
+
+func (e *ECSTask) ecsClient() (ECSClient, error) {
+	if e.Client != nil {
+		return e.Client, nil
+	}
+	return NewECSSessionWithRetryPolicy(e.ServiceKey, e.RetryPolicy)
+}
@@ -0,0 +1,65 @@
+// Package ecsfake provides an implementation of ecsutils.ECSClient for tests that records the tasks it is asked to run
+package ecsfake
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"strconv"
+	"sync"
+)
+
+// Client returns Err from RunTask when it is set, and otherwise reports Failures, or a started task for each
+// requested count when there are none
+type Client struct {
+	Err      error
+	Failures []types.Failure
+
+	mutex    sync.Mutex
+	runTasks []ecs.RunTaskInput
+}
+
+func New() *Client {
+	return &Client{}
+}
+
+// RunTaskInputs returns copies of the inputs RunTask has been called with, in order
+func (c *Client) RunTaskInputs() []ecs.RunTaskInput {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return append([]ecs.RunTaskInput(nil), c.runTasks...)
+}
+
+func (c *Client) RunTask(ctx context.Context, params *ecs.RunTaskInput, optFns ...func(*ecs.Options)) (*ecs.RunTaskOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.runTasks = append(c.runTasks, *params)
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	if len(c.Failures) > 0 {
+		return &ecs.RunTaskOutput{Failures: append([]types.Failure(nil), c.Failures...)}, nil
+	}
+
+	count := int(aws.ToInt32(params.Count))
+	if count < 1 {
+		count = 1
+	}
+	output := &ecs.RunTaskOutput{}
+	for i := 0; i < count; i++ {
+		taskArn := "arn:aws:ecs:::task/" + aws.ToString(params.Cluster) + "/" +
+			strconv.Itoa(len(c.runTasks)) + "-" + strconv.Itoa(i)
+		output.Tasks = append(output.Tasks, types.Task{
+			TaskArn:           aws.String(taskArn),
+			ClusterArn:        params.Cluster,
+			TaskDefinitionArn: params.TaskDefinition,
+			LaunchType:        params.LaunchType,
+			PlatformVersion:   params.PlatformVersion,
+			LastStatus:        aws.String("PROVISIONING"),
+		})
+	}
+	return output, nil
+}
@@ -1,8 +1,12 @@
 package ecsutils
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/tnyidea/awsutils-go/awsutils"
 )
 
 type ECSTask struct {
@@ -10,6 +14,9 @@ type ECSTask struct {
 	PlatformVersion string `json:"platformVersion"`
 	TaskDefinition  string `json:"taskDefinition"`
 	Cluster         string `json:"cluster"`
+
+	RetryPolicy *awsutils.RetryPolicy `json:"-"` // SDK default retries when nil
+	Client      ECSClient             `json:"-"` // Created from ServiceKey and RetryPolicy when nil
 }
 
 func NewECSTask(serviceKey string) ECSTask {
@@ -19,8 +26,23 @@ func NewECSTask(serviceKey string) ECSTask {
 	}
 }
 
+// NewECSTaskWithClient creates an ECSTask that sends its requests through the client instead of one created from a
+// service key
+func NewECSTaskWithClient(client ECSClient) ECSTask {
+	return ECSTask{
+		PlatformVersion: "1.4.0", // User can override this if needed
+		Client:          client,
+	}
+}
+
+// String leaves out the service key
+func (e ECSTask) String() string {
+	b, _ := json.MarshalIndent(e, "", "    ")
+	return string(b)
+}
+
 func (e *ECSTask) RunFargateTask(serviceKey string) error {
-	ecsService, err := NewECSSession(e.ServiceKey)
+	ecsService, err := e.ecsClient()
 	if err != nil {
 		return err
 	}
@@ -28,22 +50,22 @@ func (e *ECSTask) RunFargateTask(serviceKey string) error {
 	// TODO Find a way to look up the security group and subnets assigned for the taskdefinition/cluster and use
 	var securityGroup, subnet1, subnet2 string
 
-	_, err = ecsService.RunTask(&ecs.RunTaskInput{
+	taskOutput, err := ecsService.RunTask(context.Background(), &ecs.RunTaskInput{
 		// CapacityProviderStrategy: nil,
 		Cluster: aws.String(e.Cluster),
 		// Count:                    nil,
 		// EnableECSManagedTags:     nil,
 		Group:      aws.String("family:" + e.TaskDefinition),
-		LaunchType: aws.String("FARGATE"),
-		NetworkConfiguration: &ecs.NetworkConfiguration{
-			AwsvpcConfiguration: &ecs.AwsVpcConfiguration{
-				AssignPublicIp: aws.String("ENABLED"),
-				SecurityGroups: []*string{
-					aws.String(securityGroup),
+		LaunchType: types.LaunchTypeFargate,
+		NetworkConfiguration: &types.NetworkConfiguration{
+			AwsvpcConfiguration: &types.AwsVpcConfiguration{
+				AssignPublicIp: types.AssignPublicIpEnabled,
+				SecurityGroups: []string{
+					securityGroup,
 				},
-				Subnets: []*string{
-					aws.String(subnet1),
-					aws.String(subnet2),
+				Subnets: []string{
+					subnet1,
+					subnet2,
 				},
 			},
 		},
@@ -60,7 +82,19 @@ func (e *ECSTask) RunFargateTask(serviceKey string) error {
 
 	// log.Println(taskOutput) // What do we want to return from the function?
 	if err != nil {
-		return err
+		return &RunTaskError{
+			Cluster:        e.Cluster,
+			TaskDefinition: e.TaskDefinition,
+			Err:            err,
+		}
+	}
+	// RunTask succeeds even when no task could be placed, so the failures have to be checked separately
+	if len(taskOutput.Failures) > 0 {
+		return &RunTaskError{
+			Cluster:        e.Cluster,
+			TaskDefinition: e.TaskDefinition,
+			Failures:       newRunTaskFailures(taskOutput.Failures),
+		}
 	}
 
 	return nil
@@ -0,0 +1,62 @@
+package ecsutils
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"strings"
+)
+
+var ErrRunTaskFailed = errors.New("run task failed")
+
+type RunTaskFailure struct {
+	Arn    string `json:"arn"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+// RunTaskError is returned when a task could not be started, either because the RunTask request failed (Err) or
+// because ECS reported failures for it. It matches ErrRunTaskFailed with errors.Is.
+type RunTaskError struct {
+	Cluster        string           `json:"cluster"`
+	TaskDefinition string           `json:"taskDefinition"`
+	Failures       []RunTaskFailure `json:"failures"`
+	Err            error            `json:"-"`
+}
+
+func (e *RunTaskError) Error() string {
+	message := "error running task '" + e.TaskDefinition + "' on cluster '" + e.Cluster + "': "
+	if e.Err != nil {
+		return message + e.Err.Error()
+	}
+
+	var reasons []string
+	for _, failure := range e.Failures {
+		reason := failure.Reason
+		if failure.Detail != "" {
+			reason += " (" + failure.Detail + ")"
+		}
+		reasons = append(reasons, reason)
+	}
+	return message + strings.Join(reasons, ", ")
+}
+
+func (e *RunTaskError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RunTaskError) Is(target error) bool {
+	return target == ErrRunTaskFailed
+}
+
+func newRunTaskFailures(failures []types.Failure) []RunTaskFailure {
+	var runTaskFailures []RunTaskFailure
+	for _, failure := range failures {
+		runTaskFailures = append(runTaskFailures, RunTaskFailure{
+			Arn:    aws.ToString(failure.Arn),
+			Reason: aws.ToString(failure.Reason),
+			Detail: aws.ToString(failure.Detail),
+		})
+	}
+	return runTaskFailures
+}
@@ -1,14 +1,18 @@
 package ecsutils
 
 import (
-	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/tnyidea/awsutils-go/awsutils"
 )
 
-func NewECSSession(serviceKey string) (*ecs.ECS, error) {
-	awsSession, err := awsutils.NewAWSSession(serviceKey)
+func NewECSSession(serviceKey string) (*ecs.Client, error) {
+	return NewECSSessionWithRetryPolicy(serviceKey, nil)
+}
+
+func NewECSSessionWithRetryPolicy(serviceKey string, retryPolicy *awsutils.RetryPolicy) (*ecs.Client, error) {
+	awsConfig, err := awsutils.NewAWSConfigWithRetryPolicy(serviceKey, retryPolicy)
 	if err != nil {
 		return nil, err
 	}
-	return ecs.New(awsSession), nil
+	return ecs.NewFromConfig(awsConfig), nil
 }
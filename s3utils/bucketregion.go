@@ -0,0 +1,231 @@
+package s3utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/tnyidea/awsutils-go/awsutils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BucketRegionCacheTTL is how long a looked up bucket region is used before it is looked up again
+var BucketRegionCacheTTL = time.Hour
+
+// RegionalClientCacheTTL is how long the client for a service key and region is shared before it is created again,
+// which resolves a service key reference and assumes the roles of a role chain anew
+var RegionalClientCacheTTL = 15 * time.Minute
+
+// now is the clock of the bucket region and client caches
+var now = time.Now
+
+type cachedBucketRegion struct {
+	region string
+	expiry time.Time
+}
+
+// bucketRegionKey identifies a looked up region by the credentials it was looked up with as well as the bucket, since
+// the same bucket name can refer to different buckets on the endpoints of different service keys
+type bucketRegionKey struct {
+	credentials [sha256.Size]byte
+	bucket      string
+}
+
+// bucketRegionCache holds the regions set with SetBucketRegion, which apply to every service key, apart from the
+// regions that were looked up
+var bucketRegionCache struct {
+	mutex   sync.Mutex
+	pinned  map[string]string
+	entries map[bucketRegionKey]cachedBucketRegion
+}
+
+// regionalS3ClientKey identifies the credentials of a client by a hash, so that the cache does not hold service
+// keys in plain text
+type regionalS3ClientKey struct {
+	credentials [sha256.Size]byte
+	region      string
+	retryPolicy *awsutils.RetryPolicy
+}
+
+type cachedS3Client struct {
+	client *s3.Client
+	expiry time.Time
+}
+
+// regionalS3Clients holds a client per service key and bucket region, so that objects in the same bucket share it
+var regionalS3Clients struct {
+	mutex   sync.Mutex
+	entries map[regionalS3ClientKey]cachedS3Client
+}
+
+// SetBucketRegion sets the region of a bucket for every service key so that it is never looked up, such as for a
+// bucket on an endpoint that does not report its region. An empty region removes the bucket from the cache.
+func SetBucketRegion(bucket string, region string) {
+	bucketRegionCache.mutex.Lock()
+	defer bucketRegionCache.mutex.Unlock()
+
+	if region == "" {
+		delete(bucketRegionCache.pinned, bucket)
+		for key := range bucketRegionCache.entries {
+			if key.bucket == bucket {
+				delete(bucketRegionCache.entries, key)
+			}
+		}
+		return
+	}
+	if bucketRegionCache.pinned == nil {
+		bucketRegionCache.pinned = make(map[string]string)
+	}
+	bucketRegionCache.pinned[bucket] = region
+}
+
+// ClearBucketRegionCache removes every bucket region, including those set with SetBucketRegion, and the clients
+// created for them
+func ClearBucketRegionCache() {
+	bucketRegionCache.mutex.Lock()
+	bucketRegionCache.pinned = nil
+	bucketRegionCache.entries = nil
+	bucketRegionCache.mutex.Unlock()
+
+	regionalS3Clients.mutex.Lock()
+	regionalS3Clients.entries = nil
+	regionalS3Clients.mutex.Unlock()
+}
+
+// getBucketRegion returns the region of the bucket from the cache, looking it up with the credentials and endpoint
+// of the service key when it is not cached or has expired
+func getBucketRegion(bucket string, serviceKey string, retryPolicy *awsutils.RetryPolicy, roleChain []awsutils.AssumeRole) (string, error) {
+	key := bucketRegionKey{
+		credentials: credentialsHash(serviceKey, roleChain),
+		bucket:      bucket,
+	}
+	if region, cached := cachedBucketRegionOf(key); cached {
+		return region, nil
+	}
+
+	// The region of the service key is only a hint for the endpoint used to look up the bucket
+	hintRegion := awsutils.ServiceKeyRegion(serviceKey)
+	if hintRegion == "" {
+		hintRegion = "us-east-1"
+	}
+	s3Session, err := regionalS3Client(serviceKey, hintRegion, retryPolicy, roleChain)
+	if err != nil {
+		return "", err
+	}
+	region, err := manager.GetBucketRegion(context.Background(), s3Session, bucket, func(o *s3.Options) {
+		// GetBucketRegion sends the request without credentials unless they are set again
+		o.Credentials = s3Session.Options().Credentials
+	})
+	if err != nil {
+		return "", &S3Error{
+			Op:     opGetBucketRegion,
+			Bucket: bucket,
+			Err:    err,
+		}
+	}
+	if region == "" {
+		// Endpoints that do not report the region of a bucket serve it from any region
+		region = hintRegion
+	}
+
+	bucketRegionCache.mutex.Lock()
+	if bucketRegionCache.entries == nil {
+		bucketRegionCache.entries = make(map[bucketRegionKey]cachedBucketRegion)
+	}
+	for cachedKey, cached := range bucketRegionCache.entries {
+		if !now().Before(cached.expiry) {
+			delete(bucketRegionCache.entries, cachedKey)
+		}
+	}
+	bucketRegionCache.entries[key] = cachedBucketRegion{
+		region: region,
+		expiry: now().Add(BucketRegionCacheTTL),
+	}
+	bucketRegionCache.mutex.Unlock()
+
+	return region, nil
+}
+
+// cachedBucketRegionOf returns the region set for the bucket, or the region looked up with the same credentials
+// unless it has expired
+func cachedBucketRegionOf(key bucketRegionKey) (string, bool) {
+	bucketRegionCache.mutex.Lock()
+	defer bucketRegionCache.mutex.Unlock()
+
+	if region, defined := bucketRegionCache.pinned[key.bucket]; defined {
+		return region, true
+	}
+	cached, defined := bucketRegionCache.entries[key]
+	if !defined || !now().Before(cached.expiry) {
+		return "", false
+	}
+	return cached.region, true
+}
+
+// regionalS3Client returns the cached client for the service key in the region, creating it when there is none or
+// it has expired. Creating a client may resolve a service key reference or assume roles, so it happens without
+// holding the lock; when two callers race, the client cached first is kept.
+func regionalS3Client(serviceKey string, region string, retryPolicy *awsutils.RetryPolicy, roleChain []awsutils.AssumeRole) (*s3.Client, error) {
+	key := regionalS3ClientKey{
+		credentials: credentialsHash(serviceKey, roleChain),
+		region:      region,
+		retryPolicy: retryPolicy,
+	}
+
+	if client, cached := cachedRegionalS3Client(key); cached {
+		return client, nil
+	}
+
+	client, err := NewS3SessionWithRoleChain(serviceKey, retryPolicy, roleChain)
+	if err != nil {
+		return nil, err
+	}
+	if client.Options().Region != region {
+		client = s3.New(client.Options(), func(o *s3.Options) {
+			o.Region = region
+		})
+	}
+
+	regionalS3Clients.mutex.Lock()
+	defer regionalS3Clients.mutex.Unlock()
+	if cached, defined := regionalS3Clients.entries[key]; defined && now().Before(cached.expiry) {
+		return cached.client, nil
+	}
+	if regionalS3Clients.entries == nil {
+		regionalS3Clients.entries = make(map[regionalS3ClientKey]cachedS3Client)
+	}
+	for cachedKey, cached := range regionalS3Clients.entries {
+		if !now().Before(cached.expiry) {
+			delete(regionalS3Clients.entries, cachedKey)
+		}
+	}
+	regionalS3Clients.entries[key] = cachedS3Client{
+		client: client,
+		expiry: now().Add(RegionalClientCacheTTL),
+	}
+	return client, nil
+}
+
+// credentialsHash identifies the service key and role chain that requests are sent with
+func credentialsHash(serviceKey string, roleChain []awsutils.AssumeRole) [sha256.Size]byte {
+	credentials := serviceKey
+	for _, role := range roleChain {
+		credentials += "\n" + strings.Join([]string{role.RoleArn, role.ExternalId, role.SessionName,
+			strconv.FormatInt(int64(role.Duration), 10), role.SerialNumber}, "|")
+	}
+	return sha256.Sum256([]byte(credentials))
+}
+
+func cachedRegionalS3Client(key regionalS3ClientKey) (*s3.Client, bool) {
+	regionalS3Clients.mutex.Lock()
+	defer regionalS3Clients.mutex.Unlock()
+
+	cached, defined := regionalS3Clients.entries[key]
+	if !defined || !now().Before(cached.expiry) {
+		return nil, false
+	}
+	return cached.client, true
+}
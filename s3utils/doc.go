@@ -0,0 +1,6 @@
+// Package s3utils wraps common S3 tasks around S3Object and S3ObjectPrefix.
+//
+// S3ObjectPrefix implements fs.FS, fs.StatFS and fs.ReadFileFS rooted at the prefix. Its ReadDir method lists the
+// child prefixes and objects rather than fs.DirEntry values, so fs.ReadDirFS is implemented by the S3FS returned by
+// its FS method instead.
+package s3utils
@@ -0,0 +1,219 @@
+package s3utils
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+const (
+	ChecksumAlgorithmCRC32     = "CRC32"
+	ChecksumAlgorithmCRC32C    = "CRC32C"
+	ChecksumAlgorithmSHA1      = "SHA1"
+	ChecksumAlgorithmSHA256    = "SHA256"
+	ChecksumAlgorithmCRC64NVME = "CRC64NVME"
+)
+
+// checksumAlgorithms lists the supported algorithms in the order they are preferred when validating downloads
+var checksumAlgorithms = []string{
+	ChecksumAlgorithmCRC64NVME,
+	ChecksumAlgorithmCRC32C,
+	ChecksumAlgorithmCRC32,
+	ChecksumAlgorithmSHA256,
+	ChecksumAlgorithmSHA1,
+}
+
+// crc64NVMETable is built from 0x9a6c9329ac4bc9b5, the bit-reversed form that hash/crc64 expects of the CRC-64/NVME
+// polynomial 0xad93d23594c935a9
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// S3ChecksumError is returned when downloaded data does not match the checksum stored with the object
+type S3ChecksumError struct {
+	Bucket    string `json:"bucket"`
+	ObjectKey string `json:"objectKey"`
+	Algorithm string `json:"algorithm"`
+	Expected  string `json:"expected"`
+	Actual    string `json:"actual"`
+}
+
+func (e *S3ChecksumError) Error() string {
+	return "checksum mismatch for s3://" + e.Bucket + "/" + e.ObjectKey + ": expected " + e.Algorithm + " '" +
+		e.Expected + "' but computed '" + e.Actual + "'"
+}
+
+// GetChecksums loads the checksums stored with the object, keyed by algorithm, into Checksums. Checksums of
+// multipart objects may be composite values of the form <checksum>-<parts>.
+func (s *S3Object) GetChecksums() (checksums map[string]string, err error) {
+	defer wrapS3Error(&err, "HeadObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s3Session.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(s.ObjectKey),
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.Checksums = checksumMap(map[string]*string{
+		ChecksumAlgorithmCRC32:     output.ChecksumCRC32,
+		ChecksumAlgorithmCRC32C:    output.ChecksumCRC32C,
+		ChecksumAlgorithmSHA1:      output.ChecksumSHA1,
+		ChecksumAlgorithmSHA256:    output.ChecksumSHA256,
+		ChecksumAlgorithmCRC64NVME: output.ChecksumCRC64NVME,
+	})
+
+	return s.Checksums, nil
+}
+
+func checksumMap(values map[string]*string) map[string]string {
+	checksums := make(map[string]string)
+	for algorithm, value := range values {
+		if aws.ToString(value) != "" {
+			checksums[algorithm] = aws.ToString(value)
+		}
+	}
+	return checksums
+}
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumAlgorithmCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumAlgorithmCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case ChecksumAlgorithmSHA1:
+		return sha1.New(), nil
+	case ChecksumAlgorithmSHA256:
+		return sha256.New(), nil
+	case ChecksumAlgorithmCRC64NVME:
+		return crc64.New(crc64NVMETable), nil
+	}
+	return nil, errors.New("invalid checksum algorithm: unsupported algorithm '" + algorithm + "'")
+}
+
+func encodeChecksum(checksumHash hash.Hash) string {
+	return base64.StdEncoding.EncodeToString(checksumHash.Sum(nil))
+}
+
+// checksumType returns the checksum type to create a multipart upload with. S3 only supports CRC64NVME as a
+// checksum of the whole object rather than a composite of the part checksums.
+func checksumType(algorithm string) types.ChecksumType {
+	if algorithm == ChecksumAlgorithmCRC64NVME {
+		return types.ChecksumTypeFullObject
+	}
+	return ""
+}
+
+// setCompleteChecksum sends the full object CRC64NVME checksum with CompleteMultipartUpload
+func setCompleteChecksum(completeInput *s3.CompleteMultipartUploadInput, algorithm string, fullObjectHash hash.Hash) {
+	if algorithm != ChecksumAlgorithmCRC64NVME {
+		return
+	}
+	completeInput.ChecksumCRC64NVME = aws.String(encodeChecksum(fullObjectHash))
+	completeInput.ChecksumType = types.ChecksumTypeFullObject
+}
+
+// setCompletedPartChecksum records the checksum of an uploaded part for CompleteMultipartUpload. CRC64NVME is left
+// out because it is sent as a full object checksum instead.
+func setCompletedPartChecksum(completedPart *types.CompletedPart, algorithm string, checksum *string) {
+	switch algorithm {
+	case ChecksumAlgorithmCRC32:
+		completedPart.ChecksumCRC32 = checksum
+	case ChecksumAlgorithmCRC32C:
+		completedPart.ChecksumCRC32C = checksum
+	case ChecksumAlgorithmSHA1:
+		completedPart.ChecksumSHA1 = checksum
+	case ChecksumAlgorithmSHA256:
+		completedPart.ChecksumSHA256 = checksum
+	}
+}
+
+func uploadPartChecksum(uploadPartOutput *s3.UploadPartOutput, algorithm string) *string {
+	switch algorithm {
+	case ChecksumAlgorithmCRC32:
+		return uploadPartOutput.ChecksumCRC32
+	case ChecksumAlgorithmCRC32C:
+		return uploadPartOutput.ChecksumCRC32C
+	case ChecksumAlgorithmSHA1:
+		return uploadPartOutput.ChecksumSHA1
+	case ChecksumAlgorithmSHA256:
+		return uploadPartOutput.ChecksumSHA256
+	}
+	return nil
+}
+
+func copyPartChecksum(copyPartResult *types.CopyPartResult, algorithm string) *string {
+	switch algorithm {
+	case ChecksumAlgorithmCRC32:
+		return copyPartResult.ChecksumCRC32
+	case ChecksumAlgorithmCRC32C:
+		return copyPartResult.ChecksumCRC32C
+	case ChecksumAlgorithmSHA1:
+		return copyPartResult.ChecksumSHA1
+	case ChecksumAlgorithmSHA256:
+		return copyPartResult.ChecksumSHA256
+	}
+	return nil
+}
+
+// validateOutputPayloadChecksumID is the id of the SDK middleware that validates response checksums. It is internal
+// to the SDK, so a test pins it.
+const validateOutputPayloadChecksumID = "AWSChecksum:ValidateOutputPayloadChecksum"
+
+// withoutChecksumValidation removes the SDK validation of response checksums from a request, so that the data is
+// validated by a checksumValidatingReader instead and a mismatch is reported as an S3ChecksumError
+func withoutChecksumValidation(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		// The middleware is only present for operations with response checksums
+		stack.Deserialize.Remove(validateOutputPayloadChecksumID)
+		return nil
+	})
+}
+
+// checksumValidatingReader computes a checksum of the data read through it and fails with an S3ChecksumError at
+// EOF when it does not match the expected value
+type checksumValidatingReader struct {
+	body      io.ReadCloser
+	hash      hash.Hash
+	algorithm string
+	expected  string
+	s3Object  *S3Object
+}
+
+func (r *checksumValidatingReader) Read(b []byte) (int, error) {
+	n, err := r.body.Read(b)
+	r.hash.Write(b[:n])
+	if err == io.EOF {
+		actual := base64.StdEncoding.EncodeToString(r.hash.Sum(nil))
+		if actual != r.expected {
+			return n, &S3ChecksumError{
+				Bucket:    r.s3Object.Bucket,
+				ObjectKey: r.s3Object.ObjectKey,
+				Algorithm: r.algorithm,
+				Expected:  r.expected,
+				Actual:    actual,
+			}
+		}
+	}
+	return n, err
+}
+
+func (r *checksumValidatingReader) Close() error {
+	return r.body.Close()
+}
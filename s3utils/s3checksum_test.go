@@ -0,0 +1,121 @@
+package s3utils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newMismatchedChecksumClient returns a client whose GetObject responses carry a CRC32 that does not match the body
+func newMismatchedChecksumClient() *s3.Client {
+	return s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		HTTPClient: smithyhttp.ClientDoFunc(func(request *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			header.Set("x-amz-checksum-crc32", "AAAAAA==")
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        header,
+				Body:          ioutil.NopCloser(strings.NewReader("hello")),
+				ContentLength: 5,
+				Request:       request,
+			}, nil
+		}),
+	})
+}
+
+func TestChecksumValidationMiddlewareID(t *testing.T) {
+	client := newMismatchedChecksumClient()
+	input := &s3.GetObjectInput{
+		Bucket:       aws.String("bucket"),
+		Key:          aws.String("a.txt"),
+		ChecksumMode: types.ChecksumModeEnabled,
+	}
+
+	found := false
+	output, err := client.GetObject(context.Background(), input, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			_, found = stack.Deserialize.Get(validateOutputPayloadChecksumID)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	_, err = ioutil.ReadAll(output.Body)
+	if !found || err == nil {
+		log.Println("expected the SDK to validate the checksum with middleware", validateOutputPayloadChecksumID, found, err)
+		t.FailNow()
+	}
+
+	output, err = client.GetObject(context.Background(), input, withoutChecksumValidation)
+	if err != nil {
+		log.Println(err)
+		t.FailNow()
+	}
+	data, err := ioutil.ReadAll(output.Body)
+	if err != nil || string(data) != "hello" {
+		log.Println("expected the checksum not to be validated, got", string(data), err)
+		t.FailNow()
+	}
+}
+
+// sizedSeeker reports a length without holding any data
+type sizedSeeker struct {
+	size     int64
+	position int64
+}
+
+func (s *sizedSeeker) Read(b []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (s *sizedSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		s.position += offset
+	case io.SeekEnd:
+		s.position = s.size + offset
+	default:
+		s.position = offset
+	}
+	return s.position, nil
+}
+
+func TestChecksumPartSize(t *testing.T) {
+	for _, size := range []int64{0, 1 << 20, 48 << 30, 100 << 30, 5 << 40} {
+		body := &sizedSeeker{size: size, position: 1024}
+		partSize, err := checksumPartSize(body)
+		if err != nil || partSize < manager.DefaultUploadPartSize || partSize*int64(manager.MaxUploadParts) < size-1024 {
+			log.Println("expected", size, "bytes to fit in", manager.MaxUploadParts, "parts, got", partSize, err)
+			t.FailNow()
+		}
+		if body.position != 1024 {
+			log.Println("expected the body to be read from its position again, got", body.position)
+			t.FailNow()
+		}
+	}
+
+	partSize, err := checksumPartSize(strings.NewReader("hello"))
+	if err != nil || partSize != manager.DefaultUploadPartSize {
+		log.Println("expected the default part size for a small body, got", partSize, err)
+		t.FailNow()
+	}
+	partSize, err = checksumPartSize(ioutil.NopCloser(strings.NewReader("hello")))
+	if err != nil || partSize != manager.DefaultUploadPartSize {
+		log.Println("expected the default part size for a body that cannot seek, got", partSize, err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,57 @@
+package s3utils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Client is the subset of the S3 API used by this package. It is implemented by *s3.Client and by the in-memory
+// fake in the s3fake package, so that tests can inject a client instead of building one from a service key.
+type S3Client interface {
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+	SelectObjectContent(ctx context.Context, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) (*s3.SelectObjectContentOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+}
+
+var _ S3Client = (*s3.Client)(nil)
+
+// s3Client returns the injected client, or the shared client for the bucket region, looking the region up when the
+// object does not have it
+func (s *S3Object) s3Client() (S3Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	region := s.Region
+	if region == "" {
+		var err error
+		region, err = getBucketRegion(s.Bucket, s.ServiceKey, s.RetryPolicy, s.RoleChain)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return regionalS3Client(s.ServiceKey, region, s.RetryPolicy, s.RoleChain)
+}
+
+func (s *S3ObjectPrefix) s3Client() (S3Client, error) {
+	if s.Client != nil {
+		return s.Client, nil
+	}
+	region, err := getBucketRegion(s.Bucket, s.ServiceKey, s.RetryPolicy, s.RoleChain)
+	if err != nil {
+		return nil, err
+	}
+	return regionalS3Client(s.ServiceKey, region, s.RetryPolicy, s.RoleChain)
+}
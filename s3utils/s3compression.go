@@ -0,0 +1,121 @@
+package s3utils
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"path"
+	"strings"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+const metadataCompression = "Awsutils-Compression"
+
+// compressionExtensions maps file extensions to the compression used when an object has no encoding metadata
+var compressionExtensions = map[string]string{
+	".gz":   CompressionGzip,
+	".gzip": CompressionGzip,
+	".zst":  CompressionZstd,
+}
+
+// newCompressingReader returns a reader of the data compressed with the given compression. Compression runs in a
+// goroutine that stops when the returned reader is read to the end or closed.
+func newCompressingReader(reader io.Reader, compression string) (io.ReadCloser, error) {
+	var newWriter func(w io.Writer) (io.WriteCloser, error)
+	switch compression {
+	case CompressionGzip:
+		newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}
+	case CompressionZstd:
+		newWriter = func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}
+	default:
+		return nil, errors.New("invalid compression: unsupported compression '" + compression + "'")
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	compressor, err := newWriter(pipeWriter)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(compressor, reader)
+		if err != nil {
+			compressor.Close()
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		pipeWriter.CloseWithError(compressor.Close())
+	}()
+
+	return pipeReader, nil
+}
+
+// newDecompressingReader returns a reader of the decompressed body. Closing it closes the body.
+func newDecompressingReader(body io.ReadCloser, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, errors.New("error decompressing object: " + err.Error())
+		}
+		return &decompressingReader{
+			Reader: gzipReader,
+			close: func() error {
+				gzipReader.Close()
+				return body.Close()
+			},
+		}, nil
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, errors.New("error decompressing object: " + err.Error())
+		}
+		return &decompressingReader{
+			Reader: zstdReader,
+			close: func() error {
+				zstdReader.Close()
+				return body.Close()
+			},
+		}, nil
+	}
+
+	return nil, errors.New("invalid compression: unsupported compression '" + compression + "'")
+}
+
+// objectCompression returns the compression of an object from the metadata marker written on upload, falling back
+// to Content-Encoding and then to the object key extension. An empty string means the object is not compressed.
+func objectCompression(objectKey string, metadata map[string]string, contentEncoding *string) string {
+	if compression := metadataValue(metadata, metadataCompression); compression != "" {
+		return compression
+	}
+
+	for _, encoding := range strings.Split(aws.ToString(contentEncoding), ",") {
+		switch strings.TrimSpace(strings.ToLower(encoding)) {
+		case "gzip", "x-gzip":
+			return CompressionGzip
+		case "zstd":
+			return CompressionZstd
+		}
+	}
+
+	return compressionExtensions[strings.ToLower(path.Ext(objectKey))]
+}
+
+type decompressingReader struct {
+	io.Reader
+	close func() error
+}
+
+func (r *decompressingReader) Close() error {
+	return r.close()
+}
@@ -0,0 +1,302 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"strings"
+	"sync"
+)
+
+// deleteObjectsBatchSize is the maximum number of keys accepted by a single DeleteObjects request
+const deleteObjectsBatchSize = 1000
+
+type S3DeleteOptions struct {
+	Concurrency int  `json:"concurrency"` // Number of batches deleted in parallel
+	AllVersions bool `json:"allVersions"` // Delete every version and delete marker in a versioned bucket
+	DryRun      bool `json:"dryRun"`
+}
+
+type S3DeleteResult struct {
+	ObjectKey    string `json:"objectKey"`
+	VersionId    string `json:"versionId,omitempty"`
+	DeleteMarker bool   `json:"deleteMarker,omitempty"`
+	Code         string `json:"code,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+type S3DeleteReport struct {
+	DryRun  bool             `json:"dryRun"`
+	Deleted []S3DeleteResult `json:"deleted"`
+	Errors  []S3DeleteResult `json:"errors"`
+}
+
+// DeleteObjectsWithOptions deletes every object under the prefix using batched DeleteObjects requests, sending each
+// batch as soon as it is full while the listing continues. Failures for individual keys are collected in the report;
+// the returned error is only set when listing fails, in which case the report holds what was deleted until then.
+func (s *S3ObjectPrefix) DeleteObjectsWithOptions(options S3DeleteOptions) (report S3DeleteReport, err error) {
+	defer wrapS3Error(&err, "DeleteObjects", s.Bucket, s.Prefix)
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return S3DeleteReport{}, err
+	}
+
+	var deleter *objectBatchDeleter
+	if !options.DryRun {
+		deleter = newObjectBatchDeleter(s3Session, s.Bucket, options.Concurrency)
+	}
+	report = S3DeleteReport{
+		DryRun: options.DryRun,
+	}
+	add := func(objectIdentifier types.ObjectIdentifier) {
+		if options.DryRun {
+			report.Deleted = append(report.Deleted, S3DeleteResult{
+				ObjectKey: aws.ToString(objectIdentifier.Key),
+				VersionId: aws.ToString(objectIdentifier.VersionId),
+			})
+			return
+		}
+		deleter.add(objectIdentifier)
+	}
+
+	if options.AllVersions {
+		err = s.listObjectVersions(s3Session, add)
+	} else {
+		iterator := s.Iterator()
+		for iterator.Next() {
+			add(types.ObjectIdentifier{
+				Key: aws.String(iterator.Object().ObjectKey),
+			})
+		}
+		err = iterator.Err()
+	}
+
+	if deleter != nil {
+		deleterReport := deleter.close()
+		report.Deleted = deleterReport.Deleted
+		report.Errors = deleterReport.Errors
+	}
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// listObjectVersions calls fn for every version and delete marker under the prefix that matches its filters
+func (s *S3ObjectPrefix) listObjectVersions(s3Session S3Client, fn func(objectIdentifier types.ObjectIdentifier)) error {
+	filter := s.filter()
+	paginator := s3.NewListObjectVersionsPaginator(s3Session, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(s.Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return err
+		}
+		for _, version := range page.Versions {
+			if !filter(S3Object{
+				Bucket:       s.Bucket,
+				ObjectKey:    aws.ToString(version.Key),
+				ETag:         strings.ReplaceAll(aws.ToString(version.ETag), "\"", ""),
+				Size:         aws.ToInt64(version.Size),
+				StorageClass: string(version.StorageClass),
+				LastModified: aws.ToTime(version.LastModified),
+			}) {
+				continue
+			}
+			fn(types.ObjectIdentifier{
+				Key:       version.Key,
+				VersionId: version.VersionId,
+			})
+		}
+		for _, deleteMarker := range page.DeleteMarkers {
+			if !filter(S3Object{
+				Bucket:       s.Bucket,
+				ObjectKey:    aws.ToString(deleteMarker.Key),
+				LastModified: aws.ToTime(deleteMarker.LastModified),
+			}) {
+				continue
+			}
+			fn(types.ObjectIdentifier{
+				Key:       deleteMarker.Key,
+				VersionId: deleteMarker.VersionId,
+			})
+		}
+	}
+
+	return nil
+}
+
+// deleteObjectIdentifiers deletes the identifiers in concurrent DeleteObjects batches
+func deleteObjectIdentifiers(s3Session S3Client, bucket string, objectIdentifiers []types.ObjectIdentifier, concurrency int) S3DeleteReport {
+	deleter := newObjectBatchDeleter(s3Session, bucket, concurrency)
+	for _, objectIdentifier := range objectIdentifiers {
+		deleter.add(objectIdentifier)
+	}
+	return deleter.close()
+}
+
+// objectBatchDeleter sends a DeleteObjects request for every full batch of identifiers added to it, with at most
+// concurrency requests in flight. Adding blocks while every worker is busy, so that only the batches being deleted
+// are held in memory. Each time a batch or some of its keys are throttled with SlowDown fewer requests are sent at
+// once, and the throttled keys are sent again after a backoff, as in workerPool.
+type objectBatchDeleter struct {
+	s3Session S3Client
+	bucket    string
+	batch     []types.ObjectIdentifier
+	batches   chan []types.ObjectIdentifier
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	report    S3DeleteReport
+}
+
+func newObjectBatchDeleter(s3Session S3Client, bucket string, concurrency int) *objectBatchDeleter {
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+
+	deleter := &objectBatchDeleter{
+		s3Session: s3Session,
+		bucket:    bucket,
+		batches:   make(chan []types.ObjectIdentifier),
+	}
+	limiter := newAdaptiveLimiter(concurrency)
+	for w := 0; w < concurrency; w++ {
+		deleter.wg.Add(1)
+		go func() {
+			defer deleter.wg.Done()
+			for batch := range deleter.batches {
+				pending := batch
+				limiter.do(func(lastAttempt bool) bool {
+					batchReport, err := deleteObjectBatch(deleter.s3Session, deleter.bucket, pending)
+					batchReport, throttled := splitThrottled(batchReport, err)
+					if lastAttempt {
+						batchReport.Errors = append(batchReport.Errors, throttled.Errors...)
+					}
+
+					deleter.mutex.Lock()
+					deleter.report.Deleted = append(deleter.report.Deleted, batchReport.Deleted...)
+					deleter.report.Errors = append(deleter.report.Errors, batchReport.Errors...)
+					deleter.mutex.Unlock()
+
+					pending = throttled.identifiers()
+					return len(pending) > 0
+				})
+			}
+		}()
+	}
+
+	return deleter
+}
+
+func (d *objectBatchDeleter) add(objectIdentifier types.ObjectIdentifier) {
+	d.batch = append(d.batch, objectIdentifier)
+	if len(d.batch) == deleteObjectsBatchSize {
+		d.batches <- d.batch
+		d.batch = nil
+	}
+}
+
+// close sends the last partial batch and returns the combined report once every batch has been deleted
+func (d *objectBatchDeleter) close() S3DeleteReport {
+	if len(d.batch) > 0 {
+		d.batches <- d.batch
+		d.batch = nil
+	}
+	close(d.batches)
+	d.wg.Wait()
+
+	return d.report
+}
+
+// splitThrottled moves the errors of the keys that were throttled with SlowDown, or of the whole batch when the
+// request was, from the batch report to a report of their own
+func splitThrottled(batchReport S3DeleteReport, err error) (S3DeleteReport, S3DeleteReport) {
+	var throttled S3DeleteReport
+	if isSlowDown(err) {
+		throttled.Errors = batchReport.Errors
+		batchReport.Errors = nil
+		return batchReport, throttled
+	}
+
+	var remaining []S3DeleteResult
+	for _, deleteError := range batchReport.Errors {
+		if deleteError.Code == "SlowDown" {
+			throttled.Errors = append(throttled.Errors, deleteError)
+		} else {
+			remaining = append(remaining, deleteError)
+		}
+	}
+	batchReport.Errors = remaining
+	return batchReport, throttled
+}
+
+// identifiers returns the identifiers of the keys in the errors of the report
+func (r S3DeleteReport) identifiers() []types.ObjectIdentifier {
+	var objectIdentifiers []types.ObjectIdentifier
+	for _, deleteError := range r.Errors {
+		objectIdentifier := types.ObjectIdentifier{
+			Key: aws.String(deleteError.ObjectKey),
+		}
+		if deleteError.VersionId != "" {
+			objectIdentifier.VersionId = aws.String(deleteError.VersionId)
+		}
+		objectIdentifiers = append(objectIdentifiers, objectIdentifier)
+	}
+	return objectIdentifiers
+}
+
+// deleteObjectBatch deletes one batch and returns its report, along with the request error when the whole request
+// failed
+func deleteObjectBatch(s3Session S3Client, bucket string, batch []types.ObjectIdentifier) (S3DeleteReport, error) {
+	var report S3DeleteReport
+
+	output, err := s3Session.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{
+			Objects: batch,
+			Quiet:   aws.Bool(false),
+		},
+	})
+	if err != nil {
+		// The whole request failed, so every key in the batch is reported with the request error
+		code, message := "RequestError", err.Error()
+		var apiError smithy.APIError
+		if errors.As(err, &apiError) {
+			code, message = apiError.ErrorCode(), apiError.ErrorMessage()
+		}
+		for _, objectIdentifier := range batch {
+			report.Errors = append(report.Errors, S3DeleteResult{
+				ObjectKey: aws.ToString(objectIdentifier.Key),
+				VersionId: aws.ToString(objectIdentifier.VersionId),
+				Code:      code,
+				Message:   message,
+			})
+		}
+		return report, err
+	}
+
+	for _, deleted := range output.Deleted {
+		report.Deleted = append(report.Deleted, S3DeleteResult{
+			ObjectKey:    aws.ToString(deleted.Key),
+			VersionId:    aws.ToString(deleted.VersionId),
+			DeleteMarker: aws.ToBool(deleted.DeleteMarker),
+		})
+	}
+	for _, deleteError := range output.Errors {
+		report.Errors = append(report.Errors, S3DeleteResult{
+			ObjectKey: aws.ToString(deleteError.Key),
+			VersionId: aws.ToString(deleteError.VersionId),
+			Code:      aws.ToString(deleteError.Code),
+			Message:   aws.ToString(deleteError.Message),
+		})
+	}
+
+	return report, nil
+}
@@ -0,0 +1,224 @@
+package s3utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// ReadJSON streams the object into a new value of type T
+func ReadJSON[T any](s3Object S3Object, options S3DownloadOptions) (T, error) {
+	var value T
+	reader, err := s3Object.DownloadReaderWithOptions(options)
+	if err != nil {
+		return value, err
+	}
+	defer reader.Close()
+
+	err = json.NewDecoder(reader).Decode(&value)
+	if err != nil {
+		return value, err
+	}
+	return value, nil
+}
+
+// WriteJSON streams the JSON encoding of v to the object
+func (s *S3Object) WriteJSON(v interface{}, options S3UploadOptions) error {
+	if options.ContentType == "" {
+		options.ContentType = contentTypeJSON
+	}
+
+	writer := s.newStreamingUpload(options)
+	err := json.NewEncoder(writer).Encode(v)
+	if err != nil {
+		writer.abort(err)
+		return err
+	}
+	return writer.Close()
+}
+
+// S3NDJSONReader decodes newline delimited JSON values of type T from an object one at a time
+type S3NDJSONReader[T any] struct {
+	reader  io.ReadCloser
+	decoder *json.Decoder
+	value   T
+	err     error
+}
+
+func NewNDJSONReader[T any](s3Object S3Object, options S3DownloadOptions) (*S3NDJSONReader[T], error) {
+	reader, err := s3Object.DownloadReaderWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3NDJSONReader[T]{
+		reader:  reader,
+		decoder: json.NewDecoder(reader),
+	}, nil
+}
+
+func (r *S3NDJSONReader[T]) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	var value T
+	err := r.decoder.Decode(&value)
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		r.err = err
+		return false
+	}
+	r.value = value
+	return true
+}
+
+func (r *S3NDJSONReader[T]) Value() T {
+	return r.value
+}
+
+func (r *S3NDJSONReader[T]) Err() error {
+	return r.err
+}
+
+func (r *S3NDJSONReader[T]) Close() error {
+	return r.reader.Close()
+}
+
+// S3NDJSONWriter streams values to an object as newline delimited JSON. The upload completes when Close returns.
+type S3NDJSONWriter struct {
+	upload  *streamingUpload
+	encoder *json.Encoder
+}
+
+func (s *S3Object) NewNDJSONWriter(options S3UploadOptions) *S3NDJSONWriter {
+	if options.ContentType == "" {
+		options.ContentType = contentTypeNDJSON
+	}
+
+	upload := s.newStreamingUpload(options)
+	return &S3NDJSONWriter{
+		upload:  upload,
+		encoder: json.NewEncoder(upload),
+	}
+}
+
+func (w *S3NDJSONWriter) Write(v interface{}) error {
+	err := w.encoder.Encode(v)
+	if err != nil {
+		w.upload.abort(err)
+		return err
+	}
+	return nil
+}
+
+func (w *S3NDJSONWriter) Close() error {
+	return w.upload.Close()
+}
+
+// S3CSVReader reads CSV records from an object. The embedded csv.Reader may be configured before the first Read.
+type S3CSVReader struct {
+	*csv.Reader
+	reader io.ReadCloser
+}
+
+func (s *S3Object) NewCSVReader(options S3DownloadOptions) (*S3CSVReader, error) {
+	reader, err := s.DownloadReaderWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3CSVReader{
+		Reader: csv.NewReader(reader),
+		reader: reader,
+	}, nil
+}
+
+func (r *S3CSVReader) Close() error {
+	return r.reader.Close()
+}
+
+// S3CSVWriter streams CSV records to an object. The embedded csv.Writer may be configured before the first Write,
+// and the upload completes when Close returns.
+type S3CSVWriter struct {
+	*csv.Writer
+	upload *streamingUpload
+}
+
+func (s *S3Object) NewCSVWriter(options S3UploadOptions) *S3CSVWriter {
+	if options.ContentType == "" {
+		options.ContentType = contentTypeCSV
+	}
+
+	upload := s.newStreamingUpload(options)
+	return &S3CSVWriter{
+		Writer: csv.NewWriter(upload),
+		upload: upload,
+	}
+}
+
+func (w *S3CSVWriter) Close() error {
+	w.Flush()
+	if err := w.Error(); err != nil {
+		w.upload.abort(err)
+		return err
+	}
+	return w.upload.Close()
+}
+
+// streamingUpload is an io.WriteCloser that uploads the data written to it as it is written
+type streamingUpload struct {
+	pipeWriter *io.PipeWriter
+	done       chan error
+	err        error
+}
+
+func (s *S3Object) newStreamingUpload(options S3UploadOptions) *streamingUpload {
+	pipeReader, pipeWriter := io.Pipe()
+	upload := &streamingUpload{
+		pipeWriter: pipeWriter,
+		done:       make(chan error, 1),
+	}
+
+	s3Object := *s
+	go func() {
+		err := s3Object.UploadReaderWithOptions(pipeReader, options)
+		// Unblock any pending Write if the upload stopped reading early
+		pipeReader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	return upload
+}
+
+func (u *streamingUpload) Write(b []byte) (int, error) {
+	return u.pipeWriter.Write(b)
+}
+
+// Close finishes the data and waits for the upload to complete
+func (u *streamingUpload) Close() error {
+	u.pipeWriter.Close()
+	return u.wait()
+}
+
+// abort cancels the upload, so that no partial object is stored
+func (u *streamingUpload) abort(err error) {
+	u.pipeWriter.CloseWithError(err)
+	u.wait()
+}
+
+func (u *streamingUpload) wait() error {
+	if u.done != nil {
+		u.err = <-u.done
+		u.done = nil
+	}
+	return u.err
+}
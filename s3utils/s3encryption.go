@@ -0,0 +1,331 @@
+package s3utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/tnyidea/awsutils-go/awsutils"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	encryptionAlgorithm  = "AES256-GCM-CHUNKED"
+	encryptionChunkSize  = 64 * 1024
+	encryptionDataKeyLen = 32
+
+	metadataEncryption = "Awsutils-Encryption"
+	metadataWrappedKey = "Awsutils-Wrapped-Key"
+	metadataKeyWrapper = "Awsutils-Key-Wrapper"
+	metadataChunkSize  = "Awsutils-Chunk-Size"
+)
+
+// S3KeyWrapper encrypts and decrypts the per-object data keys used for client-side envelope encryption. Name is
+// stored with the object so that a download with a different kind of wrapper fails clearly.
+type S3KeyWrapper interface {
+	Name() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrappedKey []byte) ([]byte, error)
+}
+
+type localKeyWrapper struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKeyWrapper returns a key wrapper that wraps data keys with AES-GCM under a 16, 24 or 32 byte master key
+// held by the caller
+func NewLocalKeyWrapper(masterKey []byte) (S3KeyWrapper, error) {
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return nil, errors.New("invalid master key: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localKeyWrapper{aead: aead}, nil
+}
+
+func (w *localKeyWrapper) Name() string {
+	return "local"
+}
+
+func (w *localKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return w.aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+func (w *localKeyWrapper) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	if len(wrappedKey) < w.aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key: wrapped key is too short")
+	}
+	nonce, ciphertext := wrappedKey[:w.aead.NonceSize()], wrappedKey[w.aead.NonceSize():]
+	dataKey, err := w.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("error unwrapping data key: " + err.Error())
+	}
+	return dataKey, nil
+}
+
+type kmsKeyWrapper struct {
+	serviceKey string
+	keyId      string
+}
+
+// NewKMSKeyWrapper returns a key wrapper that wraps data keys with the KMS key identified by keyId, which may be a
+// key ID, key ARN or alias
+func NewKMSKeyWrapper(keyId string, serviceKey string) S3KeyWrapper {
+	return &kmsKeyWrapper{
+		serviceKey: serviceKey,
+		keyId:      keyId,
+	}
+}
+
+func (w *kmsKeyWrapper) String() string {
+	return "kms:" + w.keyId + " (" + awsutils.RedactServiceKey(w.serviceKey) + ")"
+}
+
+func (w *kmsKeyWrapper) Name() string {
+	return "kms"
+}
+
+func (w *kmsKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	awsConfig, err := awsutils.NewAWSConfig(w.serviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := kms.NewFromConfig(awsConfig).Encrypt(context.Background(), &kms.EncryptInput{
+		KeyId:     aws.String(w.keyId),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.CiphertextBlob, nil
+}
+
+func (w *kmsKeyWrapper) UnwrapKey(wrappedKey []byte) ([]byte, error) {
+	awsConfig, err := awsutils.NewAWSConfig(w.serviceKey)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := kms.NewFromConfig(awsConfig).Decrypt(context.Background(), &kms.DecryptInput{
+		CiphertextBlob: wrappedKey,
+		KeyId:          aws.String(w.keyId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
+
+// newEncryptingReader generates a data key, wraps it with keyWrapper and returns a reader of the encrypted data
+// together with the object metadata needed to decrypt it
+func newEncryptingReader(plaintext io.Reader, keyWrapper S3KeyWrapper) (io.Reader, map[string]string, error) {
+	dataKey := make([]byte, encryptionDataKeyLen)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	wrappedKey, err := keyWrapper.WrapKey(dataKey)
+	if err != nil {
+		return nil, nil, errors.New("error wrapping data key: " + err.Error())
+	}
+
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metadata := map[string]string{
+		metadataEncryption: encryptionAlgorithm,
+		metadataWrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+		metadataKeyWrapper: keyWrapper.Name(),
+		metadataChunkSize:  strconv.Itoa(encryptionChunkSize),
+	}
+
+	return &chunkEncryptingReader{
+		source:    bufio.NewReaderSize(plaintext, encryptionChunkSize),
+		aead:      aead,
+		plaintext: make([]byte, encryptionChunkSize),
+	}, metadata, nil
+}
+
+// newDecryptingReader unwraps the data key stored in the object metadata and returns a reader of the decrypted body
+func newDecryptingReader(body io.ReadCloser, metadata map[string]string, keyWrapper S3KeyWrapper) (io.ReadCloser, error) {
+	algorithm := metadataValue(metadata, metadataEncryption)
+	if algorithm == "" {
+		return nil, errors.New("error decrypting object: object is not client-side encrypted")
+	}
+	if algorithm != encryptionAlgorithm {
+		return nil, errors.New("error decrypting object: unsupported encryption algorithm '" + algorithm + "'")
+	}
+	if name := metadataValue(metadata, metadataKeyWrapper); name != keyWrapper.Name() {
+		return nil, errors.New("error decrypting object: data key was wrapped by '" + name + "' but the '" +
+			keyWrapper.Name() + "' key wrapper was provided")
+	}
+	chunkSize, err := strconv.Atoi(metadataValue(metadata, metadataChunkSize))
+	// The chunk size is read from metadata that anyone with write access to the object controls, so it is bounded
+	// by the chunk size the encrypting reader uses before any buffer is allocated
+	if err != nil || chunkSize < 1 || chunkSize > encryptionChunkSize {
+		return nil, errors.New("error decrypting object: invalid chunk size metadata")
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(metadataValue(metadata, metadataWrappedKey))
+	if err != nil {
+		return nil, errors.New("error decrypting object: invalid wrapped key metadata")
+	}
+	dataKey, err := keyWrapper.UnwrapKey(wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkDecryptingReader{
+		body:       body,
+		source:     bufio.NewReaderSize(body, chunkSize+aead.Overhead()),
+		aead:       aead,
+		ciphertext: make([]byte, chunkSize+aead.Overhead()),
+	}, nil
+}
+
+// metadataValue looks up object metadata ignoring case, since the SDK lowercases the header names it returns
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+func newChunkAEAD(dataKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, errors.New("invalid data key: " + err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce derives the nonce of a chunk from its position. Every object has its own data key, so counter nonces
+// are never reused, and marking the final chunk in the nonce detects truncation of the object.
+func chunkNonce(counter uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// readChunk fills chunk from source and reports whether it is the last chunk of the stream
+func readChunk(source *bufio.Reader, chunk []byte) (int, bool, error) {
+	n, err := io.ReadFull(source, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+
+	_, err = source.Peek(1)
+	if err == io.EOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+type chunkEncryptingReader struct {
+	source    *bufio.Reader
+	aead      cipher.AEAD
+	plaintext []byte
+	sealed    []byte
+	pending   []byte
+	counter   uint64
+	done      bool
+}
+
+func (r *chunkEncryptingReader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		n, final, err := readChunk(r.source, r.plaintext)
+		if err != nil {
+			return 0, err
+		}
+		r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.counter, final), r.plaintext[:n], nil)
+		r.pending = r.sealed
+		r.counter++
+		r.done = final
+	}
+
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+type chunkDecryptingReader struct {
+	body       io.Closer
+	source     *bufio.Reader
+	aead       cipher.AEAD
+	ciphertext []byte
+	opened     []byte
+	pending    []byte
+	counter    uint64
+	done       bool
+}
+
+func (r *chunkDecryptingReader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		n, final, err := readChunk(r.source, r.ciphertext)
+		if err != nil {
+			return 0, err
+		}
+		if n < r.aead.Overhead() {
+			return 0, errors.New("error decrypting object: encrypted data is truncated")
+		}
+		r.opened, err = r.aead.Open(r.opened[:0], chunkNonce(r.counter, final), r.ciphertext[:n], nil)
+		if err != nil {
+			return 0, errors.New("error decrypting object: chunk " + strconv.FormatUint(r.counter, 10) +
+				" failed authentication")
+		}
+		r.pending = r.opened
+		r.counter++
+		r.done = final
+	}
+
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *chunkDecryptingReader) Close() error {
+	return r.body.Close()
+}
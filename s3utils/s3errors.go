@@ -0,0 +1,145 @@
+package s3utils
+
+import (
+	"errors"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/tnyidea/awsutils-go/awsutils"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrAccessDenied   = errors.New("access denied")
+	ErrBucketRegion   = errors.New("unable to locate bucket region")
+	ErrPartialFailure = errors.New("partial failure")
+
+	// ErrInvalidServiceKey is matched by errors for empty or malformed service keys
+	ErrInvalidServiceKey = awsutils.ErrInvalidServiceKey
+)
+
+const opGetBucketRegion = "GetBucketRegion"
+
+// S3Error wraps an error from S3 with the operation and location it applies to. Use errors.Is with ErrNotFound,
+// ErrAccessDenied or ErrBucketRegion to classify it, and errors.As with smithy.APIError for the SDK details.
+type S3Error struct {
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Err    error  `json:"-"`
+}
+
+func (e *S3Error) Error() string {
+	return e.Op + " s3://" + e.Bucket + "/" + e.Key + ": " + e.Err.Error()
+}
+
+func (e *S3Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *S3Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return isNotFound(e.Err)
+	case ErrAccessDenied:
+		return isAccessDenied(e.Err)
+	case ErrBucketRegion:
+		return e.Op == opGetBucketRegion
+	}
+	return false
+}
+
+type S3ItemError struct {
+	Key     string `json:"key"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// S3PartialFailureError is returned when an operation on many objects failed for some of them. It matches
+// ErrPartialFailure with errors.Is.
+type S3PartialFailureError struct {
+	Op       string        `json:"op"`
+	Bucket   string        `json:"bucket"`
+	Failures []S3ItemError `json:"failures"`
+}
+
+func (e *S3PartialFailureError) Error() string {
+	message := e.Op + " s3://" + e.Bucket + ": " + strconv.Itoa(len(e.Failures)) + " objects failed"
+	if len(e.Failures) > 0 {
+		message += ", first failure: " + e.Failures[0].Key + ": " + e.Failures[0].Code + ": " + e.Failures[0].Message
+	}
+	return message
+}
+
+func (e *S3PartialFailureError) Is(target error) bool {
+	return target == ErrPartialFailure
+}
+
+// wrapS3Error wraps *err in an S3Error unless it is nil or already carries S3 context. It is deferred by exported
+// methods with a named error result.
+func wrapS3Error(err *error, op string, bucket string, key string) {
+	if *err == nil {
+		return
+	}
+	var s3Error *S3Error
+	var checksumError *S3ChecksumError
+	var partialFailureError *S3PartialFailureError
+	if errors.As(*err, &s3Error) || errors.As(*err, &checksumError) || errors.As(*err, &partialFailureError) {
+		return
+	}
+	*err = &S3Error{
+		Op:     op,
+		Bucket: bucket,
+		Key:    key,
+		Err:    *err,
+	}
+}
+
+func isNotFound(err error) bool {
+	if httpStatusCode(err) == http.StatusNotFound {
+		return true
+	}
+
+	switch awsErrorCode(err) {
+	case "NoSuchKey", "NoSuchBucket", "NoSuchUpload", "NoSuchVersion", "NotFound":
+		return true
+	}
+	return false
+}
+
+func isAccessDenied(err error) bool {
+	if httpStatusCode(err) == http.StatusForbidden {
+		return true
+	}
+
+	switch awsErrorCode(err) {
+	case "AccessDenied", "Forbidden", "AllAccessDisabled":
+		return true
+	}
+	return false
+}
+
+// isSlowDown reports whether S3 asked for the request rate to be reduced
+func isSlowDown(err error) bool {
+	if httpStatusCode(err) == http.StatusServiceUnavailable {
+		return true
+	}
+	return awsErrorCode(err) == "SlowDown"
+}
+
+func awsErrorCode(err error) string {
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) {
+		return apiError.ErrorCode()
+	}
+	return ""
+}
+
+func httpStatusCode(err error) int {
+	var responseError *smithyhttp.ResponseError
+	if errors.As(err, &responseError) {
+		return responseError.HTTPStatusCode()
+	}
+	return 0
+}
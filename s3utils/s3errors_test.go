@@ -0,0 +1,40 @@
+package s3utils
+
+import (
+	"errors"
+	"log"
+	"testing"
+)
+
+func TestWrapS3ErrorsOnce(t *testing.T) {
+	inner := &S3Error{Op: "HeadObject", Bucket: "bucket", Key: "key", Err: errors.New("missing")}
+	for _, wrapped := range []error{inner, &wrappingError{inner}, &wrappingError{&S3PartialFailureError{Op: "DeleteObjects"}}} {
+		err := wrapped
+		wrapS3Error(&err, "CopyObject", "bucket", "key")
+		if err != wrapped {
+			log.Println("expected", wrapped, "to be returned as is, got", err)
+			t.FailNow()
+		}
+	}
+
+	err := errors.New("failed")
+	wrapS3Error(&err, "CopyObject", "bucket", "key")
+	var s3Error *S3Error
+	if !errors.As(err, &s3Error) || s3Error.Op != "CopyObject" {
+		log.Println("expected the error to be wrapped, got", err)
+		t.FailNow()
+	}
+}
+
+// wrappingError wraps an error the way callers adding their own context do
+type wrappingError struct {
+	err error
+}
+
+func (e *wrappingError) Error() string {
+	return "context: " + e.err.Error()
+}
+
+func (e *wrappingError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,219 @@
+package s3utils
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const mebibyte = int64(1024 * 1024)
+
+// commonPartSizes are the multipart part sizes used by the AWS SDKs, the AWS CLI and this package, in the order
+// they are tried when inferring the part size of a multipart ETag
+var commonPartSizes = []int64{
+	8 * mebibyte, // AWS CLI default
+	5 * mebibyte, // s3manager default
+	16 * mebibyte,
+	100 * mebibyte, // MultipartCopy
+	15 * mebibyte,
+	32 * mebibyte,
+	64 * mebibyte,
+	128 * mebibyte,
+	256 * mebibyte,
+	512 * mebibyte,
+	1024 * mebibyte,
+}
+
+// ComputeETag returns the ETag S3 assigns to data uploaded in a single part, the hex encoded MD5 of the content
+func ComputeETag(r io.Reader) (string, error) {
+	hash := md5.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ComputeMultipartETag returns the ETag S3 assigns to data uploaded in parts of partSize bytes: the MD5 of the
+// concatenated part MD5s followed by "-" and the number of parts
+func ComputeMultipartETag(r io.Reader, partSize int64) (string, error) {
+	if partSize < 1 {
+		return "", errors.New("invalid part size: part size must be positive")
+	}
+
+	hasher := newMultipartHasher(partSize)
+	_, err := io.Copy(hasher, r)
+	if err != nil {
+		return "", err
+	}
+	return hasher.etag(), nil
+}
+
+// InferETagPartSize returns the most likely part size used to upload an object with the given multipart ETag and
+// size. Common SDK and CLI part sizes are preferred over the smallest size consistent with the part count.
+func InferETagPartSize(etag string, size int64) (int64, error) {
+	partSizes, err := inferETagPartSizes(etag, size)
+	if err != nil {
+		return 0, err
+	}
+	return partSizes[0], nil
+}
+
+// VerifyFile checks that the local file has the same content as the object by comparing ETags
+func (s *S3Object) VerifyFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.VerifyReader(file)
+}
+
+// VerifyReader checks that the data read from r has the same content as the object by comparing ETags. For
+// multipart objects every plausible part size is checked in a single pass.
+func (s *S3Object) VerifyReader(r io.Reader) error {
+	if s.ETag == "" {
+		return errors.New("cannot verify s3://" + s.Bucket + "/" + s.ObjectKey + ": object has no ETag")
+	}
+
+	matches, err := etagMatches(r, s.ETag, s.Size)
+	if err != nil {
+		return err
+	}
+	if !matches {
+		return errors.New("etag mismatch for s3://" + s.Bucket + "/" + s.ObjectKey + ": local data does not match '" +
+			s.ETag + "'")
+	}
+
+	return nil
+}
+
+func etagMatches(r io.Reader, etag string, size int64) (bool, error) {
+	etag = strings.ReplaceAll(etag, "\"", "")
+	if !isMultipartETag(etag) {
+		localETag, err := ComputeETag(r)
+		if err != nil {
+			return false, err
+		}
+		return localETag == etag, nil
+	}
+
+	partSizes, err := inferETagPartSizes(etag, size)
+	if err != nil {
+		return false, err
+	}
+
+	hashers := make([]*multipartHasher, len(partSizes))
+	writers := make([]io.Writer, len(partSizes))
+	for i, partSize := range partSizes {
+		hashers[i] = newMultipartHasher(partSize)
+		writers[i] = hashers[i]
+	}
+	_, err = io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return false, err
+	}
+
+	for _, hasher := range hashers {
+		if hasher.etag() == etag {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func inferETagPartSizes(etag string, size int64) ([]int64, error) {
+	etag = strings.ReplaceAll(etag, "\"", "")
+	tokens := strings.Split(etag, "-")
+	if len(tokens) != 2 {
+		return nil, errors.New("invalid multipart ETag: '" + etag + "' must be in the form of <md5>-<parts>")
+	}
+	parts, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil || parts < 1 {
+		return nil, errors.New("invalid multipart ETag: invalid part count in '" + etag + "'")
+	}
+
+	partCount := func(partSize int64) int64 {
+		if size == 0 {
+			return 1
+		}
+		return (size + partSize - 1) / partSize
+	}
+
+	var partSizes []int64
+	for _, partSize := range commonPartSizes {
+		if partCount(partSize) == parts {
+			partSizes = append(partSizes, partSize)
+		}
+	}
+
+	// Fall back to the smallest whole MiB part size that produces the same number of parts
+	partSize := (size + parts - 1) / parts
+	partSize = (partSize + mebibyte - 1) / mebibyte * mebibyte
+	if partSize > 0 && partCount(partSize) == parts {
+		partSizes = append(partSizes, partSize)
+	}
+
+	if len(partSizes) == 0 {
+		return nil, errors.New("unable to infer part size for ETag '" + etag + "' and size " + strconv.FormatInt(size, 10))
+	}
+	return partSizes, nil
+}
+
+// multipartHasher computes a multipart ETag as data is written to it
+type multipartHasher struct {
+	partSize    int64
+	partHash    hash.Hash
+	partWritten int64
+	partSums    []byte
+	parts       int
+}
+
+func newMultipartHasher(partSize int64) *multipartHasher {
+	return &multipartHasher{
+		partSize: partSize,
+		partHash: md5.New(),
+	}
+}
+
+func (h *multipartHasher) Write(b []byte) (int, error) {
+	written := len(b)
+	for len(b) > 0 {
+		n := int64(len(b))
+		if remaining := h.partSize - h.partWritten; n > remaining {
+			n = remaining
+		}
+		h.partHash.Write(b[:n])
+		h.partWritten += n
+		b = b[n:]
+
+		if h.partWritten == h.partSize {
+			h.endPart()
+		}
+	}
+	return written, nil
+}
+
+func (h *multipartHasher) endPart() {
+	h.partSums = h.partHash.Sum(h.partSums)
+	h.parts++
+	h.partHash.Reset()
+	h.partWritten = 0
+}
+
+func (h *multipartHasher) etag() string {
+	partSums := h.partSums
+	parts := h.parts
+	if h.partWritten > 0 || parts == 0 {
+		partSums = h.partHash.Sum(append([]byte{}, partSums...))
+		parts++
+	}
+	sum := md5.Sum(partSums)
+	return hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(parts)
+}
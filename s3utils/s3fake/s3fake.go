@@ -0,0 +1,1173 @@
+// Package s3fake provides an in-memory implementation of s3utils.S3Client for tests
+package s3fake
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crc64NVMETable is built from 0x9a6c9329ac4bc9b5, the bit-reversed form that hash/crc64 expects of the CRC-64/NVME
+// polynomial 0xad93d23594c935a9
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// Client stores objects in memory. Buckets exist as soon as an object is written to them. Requests that set a
+// ChecksumAlgorithm without a checksum value get the checksum computed, as the SDK would before sending them.
+// Buckets are not versioned unless EnableVersioning is called for them. Server side encryption is recorded but not
+// applied, and objects copied without encryption settings get none, standing in for the bucket default.
+type Client struct {
+	// OnRequest, when set, is called before every operation with its name, bucket and key. A non-nil error is
+	// returned instead of performing the operation, which allows tests to inject failures such as SlowDown.
+	// DeleteObjects also calls it for each key it deletes, reporting an error in the Errors of the output.
+	OnRequest func(operation string, bucket string, key string) error
+
+	// Select, when set, evaluates SelectObjectContent queries against the data of the object. Each returned record
+	// payload is sent as a Records event, followed by the Progress, Stats and End events, or by an error event when
+	// Select also returns an error. SelectObjectContent fails with NotImplemented when Select is not set.
+	Select func(params *s3.SelectObjectContentInput, data []byte) ([][]byte, error)
+
+	mutex         sync.Mutex
+	buckets       map[string]map[string]*object
+	versions      map[string]map[string][]objectVersion // Only for versioned buckets, oldest version first
+	uploads       map[string]*upload
+	nextUploadId  int
+	nextVersionId int
+}
+
+// objectVersion is a version of a key in a versioned bucket, with a nil object for a delete marker
+type objectVersion struct {
+	versionId    string
+	object       *object
+	lastModified time.Time
+}
+
+type object struct {
+	data               []byte
+	etag               string
+	lastModified       time.Time
+	metadata           map[string]string
+	cacheControl       *string
+	contentDisposition *string
+	contentEncoding    *string
+	contentLanguage    *string
+	contentType        *string
+	storageClass       types.StorageClass
+	sse                types.ServerSideEncryption
+	sseKMSKeyId        *string
+	bucketKeyEnabled   *bool
+	tags               []types.Tag
+	checksums          map[types.ChecksumAlgorithm]string
+	checksumType       types.ChecksumType
+	restore            *string
+}
+
+type upload struct {
+	bucket            string
+	key               string
+	template          object
+	checksumAlgorithm types.ChecksumAlgorithm
+	parts             map[int32]*object
+}
+
+func New() *Client {
+	return &Client{
+		buckets: make(map[string]map[string]*object),
+		uploads: make(map[string]*upload),
+	}
+}
+
+// Keys returns the keys stored in the bucket in lexicographical order
+func (c *Client) Keys(bucket string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.sortedKeys(bucket)
+}
+
+// EnableVersioning keeps every version of the objects written to the bucket from now on, and makes deletes without
+// a version id add a delete marker
+func (c *Client) EnableVersioning(bucket string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.versions == nil {
+		c.versions = make(map[string]map[string][]objectVersion)
+	}
+	if _, defined := c.versions[bucket]; !defined {
+		c.versions[bucket] = make(map[string][]objectVersion)
+	}
+}
+
+// MultipartUploads returns the number of multipart uploads that have been created but neither completed nor aborted
+func (c *Client) MultipartUploads() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.uploads)
+}
+
+// CorruptObject replaces the data of an object without updating its ETag or checksums, standing in for data that
+// was damaged in storage or in transit
+func (c *Client) CorruptObject(bucket string, key string, data []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if o, defined := c.bucket(bucket)[key]; defined {
+		o.data = data
+	}
+}
+
+// SetRestoreStatus sets the x-amz-restore header of an archived object, such as ongoing-request="true" for a
+// restore that is still in progress
+func (c *Client) SetRestoreStatus(bucket string, key string, restore string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if o, defined := c.bucket(bucket)[key]; defined {
+		o.restore = aws.String(restore)
+	}
+}
+
+func (c *Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	err := c.begin("AbortMultipartUpload", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	if _, defined := c.uploads[aws.ToString(params.UploadId)]; !defined {
+		return nil, &types.NoSuchUpload{Message: aws.String("the specified upload does not exist")}
+	}
+	delete(c.uploads, aws.ToString(params.UploadId))
+
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	err := c.begin("CompleteMultipartUpload", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	u, defined := c.uploads[aws.ToString(params.UploadId)]
+	if !defined {
+		return nil, &types.NoSuchUpload{Message: aws.String("the specified upload does not exist")}
+	}
+	if params.MultipartUpload == nil || len(params.MultipartUpload.Parts) == 0 {
+		return nil, apiError("MalformedXML", "the multipart upload must specify at least one part")
+	}
+
+	var data []byte
+	etagHash := md5.New()
+	compositeHash := newChecksumHash(u.checksumAlgorithm)
+	previousPartNumber := int32(0)
+	for _, completedPart := range params.MultipartUpload.Parts {
+		partNumber := aws.ToInt32(completedPart.PartNumber)
+		part, defined := u.parts[partNumber]
+		if !defined || strings.Trim(aws.ToString(completedPart.ETag), "\"") != part.etag {
+			return nil, apiError("InvalidPart", "part "+strconv.Itoa(int(partNumber))+" could not be found")
+		}
+		if partNumber <= previousPartNumber {
+			return nil, apiError("InvalidPartOrder", "the list of parts was not in ascending order")
+		}
+		previousPartNumber = partNumber
+
+		data = append(data, part.data...)
+		partETag, _ := hex.DecodeString(part.etag)
+		etagHash.Write(partETag)
+		if compositeHash != nil {
+			partChecksum, _ := base64.StdEncoding.DecodeString(part.checksums[u.checksumAlgorithm])
+			compositeHash.Write(partChecksum)
+		}
+	}
+
+	o := u.template
+	o.data = data
+	o.etag = hex.EncodeToString(etagHash.Sum(nil)) + "-" + strconv.Itoa(len(params.MultipartUpload.Parts))
+	o.lastModified = time.Now().UTC()
+	o.checksums = inputChecksums(nil, params.ChecksumCRC32, params.ChecksumCRC32C, params.ChecksumSHA1,
+		params.ChecksumSHA256, params.ChecksumCRC64NVME)
+	switch {
+	case u.checksumAlgorithm == "":
+	case o.checksumType == types.ChecksumTypeFullObject:
+		checksum := computeChecksum(u.checksumAlgorithm, data)
+		if value := o.checksums[u.checksumAlgorithm]; value != "" && value != checksum {
+			return nil, apiError("BadDigest", "the "+string(u.checksumAlgorithm)+" you specified did not match the calculated checksum")
+		}
+		o.checksums = map[types.ChecksumAlgorithm]string{u.checksumAlgorithm: checksum}
+	default:
+		o.checksums = map[types.ChecksumAlgorithm]string{
+			u.checksumAlgorithm: base64.StdEncoding.EncodeToString(compositeHash.Sum(nil)) + "-" +
+				strconv.Itoa(len(params.MultipartUpload.Parts)),
+		}
+		o.checksumType = types.ChecksumTypeComposite
+	}
+	c.put(u.bucket, u.key, &o)
+	delete(c.uploads, aws.ToString(params.UploadId))
+
+	output := &s3.CompleteMultipartUploadOutput{
+		Bucket:       aws.String(u.bucket),
+		Key:          aws.String(u.key),
+		ETag:         aws.String("\"" + o.etag + "\""),
+		ChecksumType: o.checksumType,
+	}
+	setOutputChecksums(o.checksums, &output.ChecksumCRC32, &output.ChecksumCRC32C, &output.ChecksumSHA1,
+		&output.ChecksumSHA256, &output.ChecksumCRC64NVME)
+	return output, nil
+}
+
+func (c *Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	err := c.begin("CopyObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	source, err := c.copySource(params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *source
+	o.lastModified = time.Now().UTC()
+	o.storageClass = params.StorageClass
+	o.sse = params.ServerSideEncryption
+	o.sseKMSKeyId = params.SSEKMSKeyId
+	o.bucketKeyEnabled = params.BucketKeyEnabled
+	o.restore = nil
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		o.metadata = copyMetadata(params.Metadata)
+		o.cacheControl = params.CacheControl
+		o.contentDisposition = params.ContentDisposition
+		o.contentEncoding = params.ContentEncoding
+		o.contentLanguage = params.ContentLanguage
+		o.contentType = params.ContentType
+	}
+	if params.TaggingDirective == types.TaggingDirectiveReplace {
+		o.tags, err = parseTagging(params.Tagging)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params.ChecksumAlgorithm != "" {
+		o.checksums = map[types.ChecksumAlgorithm]string{
+			params.ChecksumAlgorithm: computeChecksum(params.ChecksumAlgorithm, o.data),
+		}
+		o.checksumType = types.ChecksumTypeFullObject
+	}
+	c.put(aws.ToString(params.Bucket), aws.ToString(params.Key), &o)
+
+	copyResult := &types.CopyObjectResult{
+		ETag:         aws.String("\"" + o.etag + "\""),
+		LastModified: aws.Time(o.lastModified),
+	}
+	setOutputChecksums(o.checksums, &copyResult.ChecksumCRC32, &copyResult.ChecksumCRC32C, &copyResult.ChecksumSHA1,
+		&copyResult.ChecksumSHA256, &copyResult.ChecksumCRC64NVME)
+	return &s3.CopyObjectOutput{CopyObjectResult: copyResult}, nil
+}
+
+func (c *Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	err := c.begin("CreateMultipartUpload", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	tags, err := parseTagging(params.Tagging)
+	if err != nil {
+		return nil, err
+	}
+
+	c.nextUploadId++
+	uploadId := "upload-" + strconv.Itoa(c.nextUploadId)
+	c.uploads[uploadId] = &upload{
+		bucket: aws.ToString(params.Bucket),
+		key:    aws.ToString(params.Key),
+		template: object{
+			metadata:           copyMetadata(params.Metadata),
+			cacheControl:       params.CacheControl,
+			contentDisposition: params.ContentDisposition,
+			contentEncoding:    params.ContentEncoding,
+			contentLanguage:    params.ContentLanguage,
+			contentType:        params.ContentType,
+			storageClass:       params.StorageClass,
+			sse:                params.ServerSideEncryption,
+			sseKMSKeyId:        params.SSEKMSKeyId,
+			bucketKeyEnabled:   params.BucketKeyEnabled,
+			tags:               tags,
+			checksumType:       params.ChecksumType,
+		},
+		checksumAlgorithm: params.ChecksumAlgorithm,
+		parts:             make(map[int32]*object),
+	}
+
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:            params.Bucket,
+		Key:               params.Key,
+		UploadId:          aws.String(uploadId),
+		ChecksumAlgorithm: params.ChecksumAlgorithm,
+		ChecksumType:      params.ChecksumType,
+	}, nil
+}
+
+func (c *Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	err := c.begin("DeleteObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	deleteMarker, versionId := c.remove(aws.ToString(params.Bucket), aws.ToString(params.Key), aws.ToString(params.VersionId))
+
+	output := &s3.DeleteObjectOutput{}
+	if deleteMarker || params.VersionId != nil {
+		output.DeleteMarker = aws.Bool(deleteMarker)
+		output.VersionId = aws.String(versionId)
+	}
+	return output, nil
+}
+
+func (c *Client) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	err := c.begin("DeleteObjects", params.Bucket, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Unlock()
+
+	output := &s3.DeleteObjectsOutput{}
+	if params.Delete == nil {
+		return output, nil
+	}
+	var objectIdentifiers []types.ObjectIdentifier
+	for _, objectIdentifier := range params.Delete.Objects {
+		if c.OnRequest != nil {
+			err := c.OnRequest("DeleteObjects", aws.ToString(params.Bucket), aws.ToString(objectIdentifier.Key))
+			if err != nil {
+				code, message := "InternalError", err.Error()
+				var apiError smithy.APIError
+				if errors.As(err, &apiError) {
+					code, message = apiError.ErrorCode(), apiError.ErrorMessage()
+				}
+				output.Errors = append(output.Errors, types.Error{
+					Key:       objectIdentifier.Key,
+					VersionId: objectIdentifier.VersionId,
+					Code:      aws.String(code),
+					Message:   aws.String(message),
+				})
+				continue
+			}
+		}
+		objectIdentifiers = append(objectIdentifiers, objectIdentifier)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, objectIdentifier := range objectIdentifiers {
+		deleteMarker, versionId := c.remove(aws.ToString(params.Bucket), aws.ToString(objectIdentifier.Key),
+			aws.ToString(objectIdentifier.VersionId))
+		if aws.ToBool(params.Delete.Quiet) {
+			continue
+		}
+		deletedObject := types.DeletedObject{
+			Key:       objectIdentifier.Key,
+			VersionId: objectIdentifier.VersionId,
+		}
+		if deleteMarker {
+			deletedObject.DeleteMarker = aws.Bool(true)
+			deletedObject.DeleteMarkerVersionId = aws.String(versionId)
+		}
+		output.Deleted = append(output.Deleted, deletedObject)
+	}
+
+	return output, nil
+}
+
+func (c *Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	err := c.begin("GetObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	o, defined := c.bucket(aws.ToString(params.Bucket))[aws.ToString(params.Key)]
+	if !defined {
+		return nil, &types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+
+	output := &s3.GetObjectOutput{
+		ContentLength:      aws.Int64(int64(len(o.data))),
+		ETag:               aws.String("\"" + o.etag + "\""),
+		LastModified:       aws.Time(o.lastModified),
+		Metadata:           copyMetadata(o.metadata),
+		CacheControl:       o.cacheControl,
+		ContentDisposition: o.contentDisposition,
+		ContentEncoding:    o.contentEncoding,
+		ContentLanguage:    o.contentLanguage,
+		ContentType:        o.contentType,
+		StorageClass:       o.storageClass,
+		Restore:            o.restore,
+	}
+
+	data := o.data
+	if params.Range != nil && len(o.data) > 0 {
+		start, end, err := parseRange(aws.ToString(params.Range), int64(len(o.data)))
+		if err != nil {
+			return nil, err
+		}
+		data = o.data[start : end+1]
+		output.ContentLength = aws.Int64(int64(len(data)))
+		output.ContentRange = aws.String("bytes " + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10) +
+			"/" + strconv.Itoa(len(o.data)))
+	} else if params.ChecksumMode == types.ChecksumModeEnabled {
+		output.ChecksumType = o.checksumType
+		setOutputChecksums(o.checksums, &output.ChecksumCRC32, &output.ChecksumCRC32C, &output.ChecksumSHA1,
+			&output.ChecksumSHA256, &output.ChecksumCRC64NVME)
+	}
+	output.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	return output, nil
+}
+
+func (c *Client) GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	err := c.begin("GetObjectTagging", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	o, defined := c.bucket(aws.ToString(params.Bucket))[aws.ToString(params.Key)]
+	if !defined {
+		return nil, &types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+
+	return &s3.GetObjectTaggingOutput{
+		TagSet: append([]types.Tag(nil), o.tags...),
+	}, nil
+}
+
+func (c *Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	err := c.begin("HeadObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	o, defined := c.bucket(aws.ToString(params.Bucket))[aws.ToString(params.Key)]
+	if !defined {
+		return nil, &types.NotFound{Message: aws.String("not found")}
+	}
+
+	output := &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(int64(len(o.data))),
+		ETag:                 aws.String("\"" + o.etag + "\""),
+		LastModified:         aws.Time(o.lastModified),
+		Metadata:             copyMetadata(o.metadata),
+		CacheControl:         o.cacheControl,
+		ContentDisposition:   o.contentDisposition,
+		ContentEncoding:      o.contentEncoding,
+		ContentLanguage:      o.contentLanguage,
+		ContentType:          o.contentType,
+		StorageClass:         o.storageClass,
+		ServerSideEncryption: o.sse,
+		SSEKMSKeyId:          o.sseKMSKeyId,
+		BucketKeyEnabled:     o.bucketKeyEnabled,
+		Restore:              o.restore,
+	}
+	if params.ChecksumMode == types.ChecksumModeEnabled {
+		output.ChecksumType = o.checksumType
+		setOutputChecksums(o.checksums, &output.ChecksumCRC32, &output.ChecksumCRC32C, &output.ChecksumSHA1,
+			&output.ChecksumSHA256, &output.ChecksumCRC64NVME)
+	}
+
+	return output, nil
+}
+
+// ListObjectVersions lists every version and delete marker of a versioned bucket, newest first for each key. In a
+// bucket without versioning the current objects are listed as the only version of each key, as S3 does for a bucket
+// that has never had versioning enabled. Listings are never truncated.
+func (c *Client) ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	err := c.begin("ListObjectVersions", params.Bucket, params.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	output := &s3.ListObjectVersionsOutput{
+		Name:        params.Bucket,
+		Prefix:      params.Prefix,
+		IsTruncated: aws.Bool(false),
+	}
+	if versions, versioned := c.versions[aws.ToString(params.Bucket)]; versioned {
+		var keys []string
+		for key := range versions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !strings.HasPrefix(key, aws.ToString(params.Prefix)) || key <= aws.ToString(params.KeyMarker) {
+				continue
+			}
+			for i := len(versions[key]) - 1; i >= 0; i-- {
+				version := versions[key][i]
+				isLatest := aws.Bool(i == len(versions[key])-1)
+				if version.object == nil {
+					output.DeleteMarkers = append(output.DeleteMarkers, types.DeleteMarkerEntry{
+						Key:          aws.String(key),
+						VersionId:    aws.String(version.versionId),
+						IsLatest:     isLatest,
+						LastModified: aws.Time(version.lastModified),
+					})
+					continue
+				}
+				output.Versions = append(output.Versions, types.ObjectVersion{
+					Key:          aws.String(key),
+					VersionId:    aws.String(version.versionId),
+					IsLatest:     isLatest,
+					ETag:         aws.String("\"" + version.object.etag + "\""),
+					Size:         aws.Int64(int64(len(version.object.data))),
+					LastModified: aws.Time(version.lastModified),
+					StorageClass: types.ObjectVersionStorageClass(version.object.storageClass),
+				})
+			}
+		}
+		return output, nil
+	}
+
+	bucket := c.bucket(aws.ToString(params.Bucket))
+	for _, key := range c.sortedKeys(aws.ToString(params.Bucket)) {
+		if !strings.HasPrefix(key, aws.ToString(params.Prefix)) || key <= aws.ToString(params.KeyMarker) {
+			continue
+		}
+		o := bucket[key]
+		output.Versions = append(output.Versions, types.ObjectVersion{
+			Key:          aws.String(key),
+			VersionId:    aws.String("null"),
+			IsLatest:     aws.Bool(true),
+			ETag:         aws.String("\"" + o.etag + "\""),
+			Size:         aws.Int64(int64(len(o.data))),
+			LastModified: aws.Time(o.lastModified),
+			StorageClass: types.ObjectVersionStorageClass(o.storageClass),
+		})
+	}
+
+	return output, nil
+}
+
+func (c *Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	err := c.begin("ListObjectsV2", params.Bucket, params.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	prefix := aws.ToString(params.Prefix)
+	delimiter := aws.ToString(params.Delimiter)
+	maxKeys := int(aws.ToInt32(params.MaxKeys))
+	if params.MaxKeys == nil || maxKeys > 1000 {
+		maxKeys = 1000
+	}
+	marker := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		marker = aws.ToString(params.ContinuationToken)
+	}
+	includeRestoreStatus := false
+	for _, attribute := range params.OptionalObjectAttributes {
+		includeRestoreStatus = includeRestoreStatus || attribute == types.OptionalObjectAttributesRestoreStatus
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		Delimiter:         params.Delimiter,
+		MaxKeys:           aws.Int32(int32(maxKeys)),
+		ContinuationToken: params.ContinuationToken,
+		StartAfter:        params.StartAfter,
+	}
+	bucket := c.bucket(aws.ToString(params.Bucket))
+	count := 0
+	last := ""
+	for _, key := range c.sortedKeys(aws.ToString(params.Bucket)) {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		// A continuation token that is a common prefix covers every key under it
+		if delimiter != "" && strings.HasSuffix(marker, delimiter) && strings.HasPrefix(key, marker) {
+			continue
+		}
+
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix == last {
+			continue
+		}
+		if count == maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = aws.String(last)
+			break
+		}
+		count++
+
+		if commonPrefix != "" {
+			output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(commonPrefix)})
+			last = commonPrefix
+			continue
+		}
+		o := bucket[key]
+		listedObject := types.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String("\"" + o.etag + "\""),
+			Size:         aws.Int64(int64(len(o.data))),
+			LastModified: aws.Time(o.lastModified),
+			StorageClass: types.ObjectStorageClass(o.storageClass),
+		}
+		if listedObject.StorageClass == "" {
+			listedObject.StorageClass = types.ObjectStorageClassStandard
+		}
+		if includeRestoreStatus && o.restore != nil {
+			listedObject.RestoreStatus = &types.RestoreStatus{
+				IsRestoreInProgress: aws.Bool(strings.Contains(*o.restore, "ongoing-request=\"true\"")),
+			}
+		}
+		output.Contents = append(output.Contents, listedObject)
+		last = key
+	}
+	output.KeyCount = aws.Int32(int32(count))
+	if output.IsTruncated == nil {
+		output.IsTruncated = aws.Bool(false)
+	}
+
+	return output, nil
+}
+
+func (c *Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := readBody(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.begin("PutObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	tags, err := parseTagging(params.Tagging)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := md5.Sum(data)
+	o := &object{
+		data:               data,
+		etag:               hex.EncodeToString(etag[:]),
+		lastModified:       time.Now().UTC(),
+		metadata:           copyMetadata(params.Metadata),
+		cacheControl:       params.CacheControl,
+		contentDisposition: params.ContentDisposition,
+		contentEncoding:    params.ContentEncoding,
+		contentLanguage:    params.ContentLanguage,
+		contentType:        params.ContentType,
+		storageClass:       params.StorageClass,
+		sse:                params.ServerSideEncryption,
+		sseKMSKeyId:        params.SSEKMSKeyId,
+		bucketKeyEnabled:   params.BucketKeyEnabled,
+		tags:               tags,
+	}
+	o.checksums, err = requestChecksums(params.ChecksumAlgorithm, data, params.ChecksumCRC32, params.ChecksumCRC32C,
+		params.ChecksumSHA1, params.ChecksumSHA256, params.ChecksumCRC64NVME)
+	if err != nil {
+		return nil, err
+	}
+	if len(o.checksums) > 0 {
+		o.checksumType = types.ChecksumTypeFullObject
+	}
+	c.put(aws.ToString(params.Bucket), aws.ToString(params.Key), o)
+
+	output := &s3.PutObjectOutput{
+		ETag:         aws.String("\"" + o.etag + "\""),
+		Size:         aws.Int64(int64(len(data))),
+		ChecksumType: o.checksumType,
+	}
+	setOutputChecksums(o.checksums, &output.ChecksumCRC32, &output.ChecksumCRC32C, &output.ChecksumSHA1,
+		&output.ChecksumSHA256, &output.ChecksumCRC64NVME)
+	return output, nil
+}
+
+// RestoreObject makes the restored copy available immediately, unless a restore set with SetRestoreStatus is still
+// in progress
+func (c *Client) RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error) {
+	err := c.begin("RestoreObject", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	o, defined := c.bucket(aws.ToString(params.Bucket))[aws.ToString(params.Key)]
+	if !defined {
+		return nil, &types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+	if o.storageClass != types.StorageClassGlacier && o.storageClass != types.StorageClassDeepArchive {
+		return nil, &types.InvalidObjectState{Message: aws.String("the operation is not valid for the object's storage class")}
+	}
+	if o.restore != nil && strings.Contains(*o.restore, "ongoing-request=\"true\"") {
+		return nil, apiError("RestoreAlreadyInProgress", "object restore is already in progress")
+	}
+
+	days := int32(1)
+	if params.RestoreRequest != nil && params.RestoreRequest.Days != nil {
+		days = *params.RestoreRequest.Days
+	}
+	expiryDate := time.Now().UTC().Add(time.Duration(days) * 24 * time.Hour).Format(time.RFC1123)
+	o.restore = aws.String("ongoing-request=\"false\", expiry-date=\"" + expiryDate + "\"")
+
+	return &s3.RestoreObjectOutput{}, nil
+}
+
+func (c *Client) SelectObjectContent(ctx context.Context, params *s3.SelectObjectContentInput, optFns ...func(*s3.Options)) (*s3.SelectObjectContentOutput, error) {
+	err := c.begin("SelectObjectContent", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	o, defined := c.bucket(aws.ToString(params.Bucket))[aws.ToString(params.Key)]
+	var data []byte
+	if defined {
+		data = o.data
+	}
+	c.mutex.Unlock()
+
+	if !defined {
+		return nil, &types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+	if c.Select == nil {
+		return nil, apiError("NotImplemented", "SelectObjectContent is not supported without a Select function")
+	}
+	records, selectErr := c.Select(params, data)
+
+	var body bytes.Buffer
+	encoder := eventstream.NewEncoder()
+	bytesReturned := 0
+	for _, record := range records {
+		bytesReturned += len(record)
+		err = encodeSelectEvent(encoder, &body, "Records", "application/octet-stream", record)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if selectErr != nil {
+		code, message := "InternalError", selectErr.Error()
+		var apiErr smithy.APIError
+		if errors.As(selectErr, &apiErr) {
+			code, message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+		}
+		err = encoder.Encode(&body, eventstream.Message{
+			Headers: eventstream.Headers{
+				{Name: ":message-type", Value: eventstream.StringValue("error")},
+				{Name: ":error-code", Value: eventstream.StringValue(code)},
+				{Name: ":error-message", Value: eventstream.StringValue(message)},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		details := "<BytesScanned>" + strconv.Itoa(len(data)) + "</BytesScanned><BytesProcessed>" +
+			strconv.Itoa(len(data)) + "</BytesProcessed><BytesReturned>" + strconv.Itoa(bytesReturned) + "</BytesReturned>"
+		events := [][2]string{{"Stats", "<Stats>" + details + "</Stats>"}, {"End", ""}}
+		if params.RequestProgress != nil && aws.ToBool(params.RequestProgress.Enabled) {
+			events = append([][2]string{{"Progress", "<Progress>" + details + "</Progress>"}}, events...)
+		}
+		for _, event := range events {
+			err = encodeSelectEvent(encoder, &body, event[0], "text/xml", []byte(event[1]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The event stream of the output can only be created by decoding a response, so the events are decoded by a
+	// client whose HTTP client answers with them
+	decodingClient := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     aws.NopRetryer{},
+		HTTPClient: smithyhttp.ClientDoFunc(func(request *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/vnd.amazon.eventstream"}},
+				Body:       ioutil.NopCloser(&body),
+				Request:    request,
+			}, nil
+		}),
+	})
+	return decodingClient.SelectObjectContent(ctx, params)
+}
+
+func encodeSelectEvent(encoder *eventstream.Encoder, w io.Writer, eventType string, contentType string, payload []byte) error {
+	return encoder.Encode(w, eventstream.Message{
+		Headers: eventstream.Headers{
+			{Name: ":message-type", Value: eventstream.StringValue("event")},
+			{Name: ":event-type", Value: eventstream.StringValue(eventType)},
+			{Name: ":content-type", Value: eventstream.StringValue(contentType)},
+		},
+		Payload: payload,
+	})
+}
+
+func (c *Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := readBody(params.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.begin("UploadPart", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	u, defined := c.uploads[aws.ToString(params.UploadId)]
+	if !defined {
+		return nil, &types.NoSuchUpload{Message: aws.String("the specified upload does not exist")}
+	}
+
+	checksumAlgorithm := params.ChecksumAlgorithm
+	if checksumAlgorithm == "" {
+		checksumAlgorithm = u.checksumAlgorithm
+	}
+	etag := md5.Sum(data)
+	part := &object{
+		data: data,
+		etag: hex.EncodeToString(etag[:]),
+	}
+	part.checksums, err = requestChecksums(checksumAlgorithm, data, params.ChecksumCRC32, params.ChecksumCRC32C,
+		params.ChecksumSHA1, params.ChecksumSHA256, params.ChecksumCRC64NVME)
+	if err != nil {
+		return nil, err
+	}
+	u.parts[aws.ToInt32(params.PartNumber)] = part
+
+	output := &s3.UploadPartOutput{
+		ETag: aws.String("\"" + part.etag + "\""),
+	}
+	setOutputChecksums(part.checksums, &output.ChecksumCRC32, &output.ChecksumCRC32C, &output.ChecksumSHA1,
+		&output.ChecksumSHA256, &output.ChecksumCRC64NVME)
+	return output, nil
+}
+
+func (c *Client) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	err := c.begin("UploadPartCopy", params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.mutex.Unlock()
+
+	u, defined := c.uploads[aws.ToString(params.UploadId)]
+	if !defined {
+		return nil, &types.NoSuchUpload{Message: aws.String("the specified upload does not exist")}
+	}
+	source, err := c.copySource(params.CopySource)
+	if err != nil {
+		return nil, err
+	}
+
+	data := source.data
+	if params.CopySourceRange != nil {
+		start, end, err := parseRange(aws.ToString(params.CopySourceRange), int64(len(source.data)))
+		if err != nil {
+			return nil, err
+		}
+		data = source.data[start : end+1]
+	}
+
+	etag := md5.Sum(data)
+	part := &object{
+		data: append([]byte(nil), data...),
+		etag: hex.EncodeToString(etag[:]),
+	}
+	part.checksums, _ = requestChecksums(u.checksumAlgorithm, data, nil, nil, nil, nil, nil)
+	u.parts[aws.ToInt32(params.PartNumber)] = part
+
+	copyPartResult := &types.CopyPartResult{
+		ETag:         aws.String("\"" + part.etag + "\""),
+		LastModified: aws.Time(time.Now().UTC()),
+	}
+	setOutputChecksums(part.checksums, &copyPartResult.ChecksumCRC32, &copyPartResult.ChecksumCRC32C,
+		&copyPartResult.ChecksumSHA1, &copyPartResult.ChecksumSHA256, &copyPartResult.ChecksumCRC64NVME)
+	return &s3.UploadPartCopyOutput{CopyPartResult: copyPartResult}, nil
+}
+
+// begin calls OnRequest and locks the client, which the caller must unlock unless an error is returned
+func (c *Client) begin(operation string, bucket *string, key *string) error {
+	if c.OnRequest != nil {
+		err := c.OnRequest(operation, aws.ToString(bucket), aws.ToString(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mutex.Lock()
+	if c.buckets == nil {
+		c.buckets = make(map[string]map[string]*object)
+		c.uploads = make(map[string]*upload)
+	}
+	return nil
+}
+
+// put stores the object as the current version of the key, keeping the previous versions of a versioned bucket
+func (c *Client) put(bucket string, key string, o *object) {
+	c.bucket(bucket)[key] = o
+
+	if versions, versioned := c.versions[bucket]; versioned {
+		c.nextVersionId++
+		versions[key] = append(versions[key], objectVersion{
+			versionId:    "version-" + strconv.Itoa(c.nextVersionId),
+			object:       o,
+			lastModified: o.lastModified,
+		})
+	}
+}
+
+// remove deletes a key as DeleteObject does. In a versioned bucket a delete without a version id adds a delete
+// marker, whose version id is returned, and deleting a version makes the version before it current.
+func (c *Client) remove(bucket string, key string, versionId string) (bool, string) {
+	versions, versioned := c.versions[bucket]
+	if !versioned {
+		delete(c.bucket(bucket), key)
+		return false, ""
+	}
+
+	if versionId == "" {
+		c.nextVersionId++
+		versionId = "version-" + strconv.Itoa(c.nextVersionId)
+		versions[key] = append(versions[key], objectVersion{
+			versionId:    versionId,
+			lastModified: time.Now().UTC(),
+		})
+		delete(c.bucket(bucket), key)
+		return true, versionId
+	}
+
+	deleteMarker := false
+	for i, version := range versions[key] {
+		if version.versionId == versionId {
+			deleteMarker = version.object == nil
+			versions[key] = append(versions[key][:i], versions[key][i+1:]...)
+			break
+		}
+	}
+	if len(versions[key]) == 0 {
+		delete(versions, key)
+		delete(c.bucket(bucket), key)
+	} else if current := versions[key][len(versions[key])-1]; current.object != nil {
+		c.bucket(bucket)[key] = current.object
+	} else {
+		delete(c.bucket(bucket), key)
+	}
+	return deleteMarker, versionId
+}
+
+func (c *Client) bucket(name string) map[string]*object {
+	bucket, defined := c.buckets[name]
+	if !defined {
+		bucket = make(map[string]*object)
+		c.buckets[name] = bucket
+	}
+	return bucket
+}
+
+func (c *Client) sortedKeys(bucket string) []string {
+	var keys []string
+	for key := range c.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copySource returns the object named by a CopySource of the form bucket/key, optionally with a leading slash
+func (c *Client) copySource(copySource *string) (*object, error) {
+	source, err := url.PathUnescape(aws.ToString(copySource))
+	if err != nil {
+		return nil, apiError("InvalidArgument", "invalid copy source: "+err.Error())
+	}
+	tokens := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
+	if len(tokens) != 2 {
+		return nil, apiError("InvalidArgument", "invalid copy source: copy source must be in the form bucket/key")
+	}
+
+	o, defined := c.bucket(tokens[0])[tokens[1]]
+	if !defined {
+		return nil, &types.NoSuchKey{Message: aws.String("the specified key does not exist")}
+	}
+	return o, nil
+}
+
+func apiError(code string, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message, Fault: smithy.FaultClient}
+}
+
+func readBody(body io.Reader) ([]byte, error) {
+	if body == nil {
+		return []byte{}, nil
+	}
+	return ioutil.ReadAll(body)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+	metadataCopy := make(map[string]string)
+	for key, value := range metadata {
+		// S3 returns user metadata keys in lower case
+		metadataCopy[strings.ToLower(key)] = value
+	}
+	return metadataCopy
+}
+
+func parseTagging(tagging *string) ([]types.Tag, error) {
+	if tagging == nil {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(*tagging)
+	if err != nil {
+		return nil, apiError("InvalidArgument", "invalid tagging: "+err.Error())
+	}
+
+	var tags []types.Tag
+	for key := range values {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(values.Get(key))})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return aws.ToString(tags[i].Key) < aws.ToString(tags[j].Key)
+	})
+	return tags, nil
+}
+
+// parseRange parses a single byte range of the form bytes=start-end or bytes=start-
+func parseRange(byteRange string, size int64) (int64, int64, error) {
+	invalidRange := apiError("InvalidRange", "the requested range '"+byteRange+"' is not satisfiable")
+
+	tokens := strings.SplitN(strings.TrimPrefix(byteRange, "bytes="), "-", 2)
+	if len(tokens) != 2 {
+		return 0, 0, invalidRange
+	}
+	start, err := strconv.ParseInt(tokens[0], 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, invalidRange
+	}
+	end := size - 1
+	if tokens[1] != "" {
+		end, err = strconv.ParseInt(tokens[1], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, invalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, nil
+}
+
+func newChecksumHash(algorithm types.ChecksumAlgorithm) hash.Hash {
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32:
+		return crc32.NewIEEE()
+	case types.ChecksumAlgorithmCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case types.ChecksumAlgorithmSha1:
+		return sha1.New()
+	case types.ChecksumAlgorithmSha256:
+		return sha256.New()
+	case types.ChecksumAlgorithmCrc64nvme:
+		return crc64.New(crc64NVMETable)
+	}
+	return nil
+}
+
+func computeChecksum(algorithm types.ChecksumAlgorithm, data []byte) string {
+	checksumHash := newChecksumHash(algorithm)
+	if checksumHash == nil {
+		return ""
+	}
+	checksumHash.Write(data)
+	return base64.StdEncoding.EncodeToString(checksumHash.Sum(nil))
+}
+
+func inputChecksums(checksums map[types.ChecksumAlgorithm]string, crc32Checksum, crc32cChecksum, sha1Checksum,
+	sha256Checksum, crc64NVMEChecksum *string) map[types.ChecksumAlgorithm]string {
+	if checksums == nil {
+		checksums = make(map[types.ChecksumAlgorithm]string)
+	}
+	for algorithm, value := range map[types.ChecksumAlgorithm]*string{
+		types.ChecksumAlgorithmCrc32:     crc32Checksum,
+		types.ChecksumAlgorithmCrc32c:    crc32cChecksum,
+		types.ChecksumAlgorithmSha1:      sha1Checksum,
+		types.ChecksumAlgorithmSha256:    sha256Checksum,
+		types.ChecksumAlgorithmCrc64nvme: crc64NVMEChecksum,
+	} {
+		if aws.ToString(value) != "" {
+			checksums[algorithm] = aws.ToString(value)
+		}
+	}
+	return checksums
+}
+
+// requestChecksums collects the checksums sent with a request, computing the one for algorithm when it is missing
+// and rejecting any that do not match the data, as S3 does
+func requestChecksums(algorithm types.ChecksumAlgorithm, data []byte, crc32Checksum, crc32cChecksum, sha1Checksum,
+	sha256Checksum, crc64NVMEChecksum *string) (map[types.ChecksumAlgorithm]string, error) {
+	checksums := inputChecksums(nil, crc32Checksum, crc32cChecksum, sha1Checksum, sha256Checksum, crc64NVMEChecksum)
+	for checksumAlgorithm, value := range checksums {
+		if computeChecksum(checksumAlgorithm, data) != value {
+			return nil, apiError("BadDigest", "the "+string(checksumAlgorithm)+" you specified did not match the calculated checksum")
+		}
+	}
+	if algorithm != "" && checksums[algorithm] == "" {
+		checksums[algorithm] = computeChecksum(algorithm, data)
+	}
+	return checksums, nil
+}
+
+func setOutputChecksums(checksums map[types.ChecksumAlgorithm]string, crc32Checksum, crc32cChecksum, sha1Checksum,
+	sha256Checksum, crc64NVMEChecksum **string) {
+	for algorithm, value := range map[types.ChecksumAlgorithm]**string{
+		types.ChecksumAlgorithmCrc32:     crc32Checksum,
+		types.ChecksumAlgorithmCrc32c:    crc32cChecksum,
+		types.ChecksumAlgorithmSha1:      sha1Checksum,
+		types.ChecksumAlgorithmSha256:    sha256Checksum,
+		types.ChecksumAlgorithmCrc64nvme: crc64NVMEChecksum,
+	} {
+		if checksums[algorithm] != "" {
+			*value = aws.String(checksums[algorithm])
+		}
+	}
+}
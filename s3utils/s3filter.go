@@ -0,0 +1,169 @@
+package s3utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// S3ObjectFilter reports whether an object should be included in a listing
+type S3ObjectFilter func(s3Object S3Object) bool
+
+func ModifiedAfter(afterTime time.Time) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return s3Object.LastModified.After(afterTime)
+	}
+}
+
+func ModifiedBefore(beforeTime time.Time) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return s3Object.LastModified.Before(beforeTime)
+	}
+}
+
+// AllOf matches objects accepted by every filter
+func AllOf(filters ...S3ObjectFilter) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		for _, filter := range filters {
+			if !filter(s3Object) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf matches objects accepted by at least one filter
+func AnyOf(filters ...S3ObjectFilter) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		for _, filter := range filters {
+			if filter(s3Object) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func Not(filter S3ObjectFilter) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return !filter(s3Object)
+	}
+}
+
+// MatchGlob matches object keys against a glob pattern. "*" and "?" do not match "/", "**" matches any number of
+// path segments, "[...]" matches a character class and "{a,b}" matches either alternative, for example
+// logs/**/2024-*.gz
+func MatchGlob(pattern string) (S3ObjectFilter, error) {
+	expression, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return MatchRegexp(expression), nil
+}
+
+func MatchRegexp(expression *regexp.Regexp) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return expression.MatchString(s3Object.ObjectKey)
+	}
+}
+
+// SizeBetween matches objects whose size is within [minSize, maxSize]. A maxSize of zero means no upper bound.
+func SizeBetween(minSize int64, maxSize int64) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return s3Object.Size >= minSize && (maxSize == 0 || s3Object.Size <= maxSize)
+	}
+}
+
+func StorageClassIn(storageClasses ...string) S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		for _, storageClass := range storageClasses {
+			if s3Object.StorageClass == storageClass {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func ETagEquals(etag string) S3ObjectFilter {
+	etag = strings.ReplaceAll(etag, "\"", "")
+	return func(s3Object S3Object) bool {
+		return s3Object.ETag == etag
+	}
+}
+
+// IsMultipartUpload matches objects that were uploaded in multiple parts, identified by their "<md5>-<parts>" ETag
+func IsMultipartUpload() S3ObjectFilter {
+	return func(s3Object S3Object) bool {
+		return isMultipartETag(s3Object.ETag)
+	}
+}
+
+// WithFilters returns a copy of the prefix whose listing, size, delete, restore, storage class and copy operations
+// only consider objects accepted by every filter
+func (s *S3ObjectPrefix) WithFilters(filters ...S3ObjectFilter) S3ObjectPrefix {
+	prefix := *s
+	prefix.Filters = append(append([]S3ObjectFilter{}, s.Filters...), filters...)
+	return prefix
+}
+
+func (s *S3ObjectPrefix) filter(filters ...S3ObjectFilter) S3ObjectFilter {
+	return AllOf(append(append([]S3ObjectFilter{}, s.Filters...), filters...)...)
+}
+
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var expression strings.Builder
+	expression.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// "**/" also matches zero segments so that a/**/b matches a/b
+					i++
+					expression.WriteString("(?:.*/)?")
+				} else {
+					expression.WriteString(".*")
+				}
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("invalid glob pattern: unterminated character class in '" + pattern + "'")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + strings.ReplaceAll(class, "\\", "\\\\") + "]")
+			i += end + 1
+		case '{':
+			end := strings.IndexByte(pattern[i+1:], '}')
+			if end < 0 {
+				return nil, errors.New("invalid glob pattern: unterminated alternative in '" + pattern + "'")
+			}
+			alternatives := strings.Split(pattern[i+1:i+1+end], ",")
+			for j := range alternatives {
+				alternatives[j] = regexp.QuoteMeta(alternatives[j])
+			}
+			expression.WriteString("(?:" + strings.Join(alternatives, "|") + ")")
+			i += end + 1
+		default:
+			expression.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
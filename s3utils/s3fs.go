@@ -0,0 +1,338 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3ObjectPrefix implements fs.FS rooted at the prefix. Object keys are split on "/" to emulate directories, so
+// the object <prefix>/a/b.txt is opened as "a/b.txt".
+var (
+	_ fs.FS         = (*S3ObjectPrefix)(nil)
+	_ fs.StatFS     = (*S3ObjectPrefix)(nil)
+	_ fs.ReadFileFS = (*S3ObjectPrefix)(nil)
+	_ fs.ReadDirFS  = (*S3FS)(nil)
+	_ fs.StatFS     = (*S3FS)(nil)
+	_ fs.ReadFileFS = (*S3FS)(nil)
+)
+
+// S3FS is the file system of a prefix including fs.ReadDirFS, which S3ObjectPrefix cannot implement because its
+// ReadDir lists child prefixes and objects
+type S3FS struct {
+	prefix S3ObjectPrefix
+}
+
+// FS returns the file system rooted at the prefix
+func (s *S3ObjectPrefix) FS() *S3FS {
+	return &S3FS{prefix: *s}
+}
+
+func (f *S3FS) Open(name string) (fs.File, error) {
+	return f.prefix.Open(name)
+}
+
+func (f *S3FS) Stat(name string) (fs.FileInfo, error) {
+	return f.prefix.Stat(name)
+}
+
+func (f *S3FS) ReadFile(name string) ([]byte, error) {
+	return f.prefix.ReadFile(name)
+}
+
+func (f *S3FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return f.prefix.readDirEntries(name)
+}
+
+func (s *S3ObjectPrefix) Open(name string) (fs.File, error) {
+	info, err := s.stat("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &s3Dir{
+			prefix: s,
+			name:   name,
+			info:   info,
+		}, nil
+	}
+
+	return &s3File{
+		prefix: s,
+		info:   info,
+	}, nil
+}
+
+func (s *S3ObjectPrefix) Stat(name string) (fs.FileInfo, error) {
+	info, err := s.stat("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (s *S3ObjectPrefix) ReadFile(name string) ([]byte, error) {
+	info, err := s.stat("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errIsDirectory}
+	}
+
+	body, err := info.s3Object.getObjectBody()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	defer body.Close()
+
+	return ioutil.ReadAll(body)
+}
+
+func (s *S3ObjectPrefix) readDirEntries(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	directory := *s
+	directory.Prefix = s.fsKey(name)
+	childPrefixes, objects, err := directory.ReadDir()
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	var entries []fs.DirEntry
+	for _, childPrefix := range childPrefixes {
+		entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{
+			name: path.Base(childPrefix.Prefix),
+			dir:  true,
+		}))
+	}
+	for i := range objects {
+		// Skip the zero byte placeholder the S3 console creates for folders
+		if strings.HasSuffix(objects[i].ObjectKey, prefixDelimiter) {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(newS3FileInfo(objects[i])))
+	}
+
+	if len(entries) == 0 && name != "." {
+		if _, err := s.stat("readdir", name); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// fsKey returns the object key, or directory prefix for ".", for an fs path relative to the prefix
+func (s *S3ObjectPrefix) fsKey(name string) string {
+	if name == "." {
+		return s.directoryPrefix()
+	}
+	return s.directoryPrefix() + name
+}
+
+func (s *S3ObjectPrefix) stat(op string, name string) (*s3FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &s3FileInfo{name: ".", dir: true}, nil
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	key := s.fsKey(name)
+	output, err := s3Session.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		s3Object := S3Object{
+			ServiceKey:   s.ServiceKey,
+			Bucket:       s.Bucket,
+			ObjectKey:    key,
+			Exists:       true,
+			ETag:         strings.ReplaceAll(aws.ToString(output.ETag), "\"", ""),
+			Size:         aws.ToInt64(output.ContentLength),
+			StorageClass: string(output.StorageClass),
+			LastModified: aws.ToTime(output.LastModified),
+			RetryPolicy:  s.RetryPolicy,
+			RoleChain:    s.RoleChain,
+			Client:       s.Client,
+		}
+		if s.filter()(s3Object) {
+			return newS3FileInfo(s3Object), nil
+		}
+	} else if !isNotFound(err) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+
+	// There is no object with this key, so check whether it is used as a directory
+	listOutput, err := s3Session.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.Bucket),
+		Prefix:  aws.String(key + prefixDelimiter),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if aws.ToInt32(listOutput.KeyCount) == 0 {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &s3FileInfo{name: path.Base(name), dir: true}, nil
+}
+
+var errIsDirectory = errors.New("is a directory")
+
+type s3FileInfo struct {
+	name     string
+	dir      bool
+	s3Object S3Object
+}
+
+func newS3FileInfo(s3Object S3Object) *s3FileInfo {
+	return &s3FileInfo{
+		name:     path.Base(s3Object.ObjectKey),
+		s3Object: s3Object,
+	}
+}
+
+func (i *s3FileInfo) Name() string {
+	return i.name
+}
+
+func (i *s3FileInfo) Size() int64 {
+	return i.s3Object.Size
+}
+
+func (i *s3FileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i *s3FileInfo) ModTime() time.Time {
+	return i.s3Object.LastModified
+}
+
+func (i *s3FileInfo) IsDir() bool {
+	return i.dir
+}
+
+// Sys returns the S3Object backing a file, or nil for a directory
+func (i *s3FileInfo) Sys() interface{} {
+	if i.dir {
+		return nil
+	}
+	return i.s3Object
+}
+
+type s3File struct {
+	prefix *S3ObjectPrefix
+	info   *s3FileInfo
+	body   io.ReadCloser
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *s3File) Read(b []byte) (int, error) {
+	if f.body == nil {
+		body, err := f.info.s3Object.getObjectBody()
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.info.name, Err: err}
+		}
+		f.body = body
+	}
+	return f.body.Read(b)
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+type s3Dir struct {
+	prefix  *S3ObjectPrefix
+	name    string
+	info    *s3FileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	offset  int
+}
+
+func (d *s3Dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *s3Dir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *s3Dir) Close() error {
+	return nil
+}
+
+func (d *s3Dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.prefix.readDirEntries(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.loaded = true
+	}
+
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (s *S3Object) getObjectBody() (io.ReadCloser, error) {
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s3Session.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Body, nil
+}
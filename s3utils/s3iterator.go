@@ -0,0 +1,114 @@
+package s3utils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"strings"
+)
+
+// S3ObjectIterator walks the objects under a prefix one page at a time so that only a single page of results is
+// held in memory. Use it as:
+//
+//	iterator := prefix.Iterator()
+//	for iterator.Next() {
+//		s3Object := iterator.Object()
+//	}
+//	if err := iterator.Err(); err != nil {
+//	}
+type S3ObjectIterator struct {
+	prefix            S3ObjectPrefix
+	filter            S3ObjectFilter
+	s3Session         S3Client
+	page              []types.Object
+	pageIndex         int
+	continuationToken *string
+	lastPage          bool
+	current           S3Object
+	err               error
+}
+
+func (s *S3ObjectPrefix) Iterator(filters ...S3ObjectFilter) *S3ObjectIterator {
+	return &S3ObjectIterator{
+		prefix: *s,
+		filter: s.filter(filters...),
+	}
+}
+
+func (it *S3ObjectIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for {
+		for it.pageIndex < len(it.page) {
+			s3Object := newS3ObjectFromListing(it.prefix, it.page[it.pageIndex])
+			it.pageIndex++
+			if it.filter(s3Object) {
+				it.current = s3Object
+				return true
+			}
+		}
+
+		if it.lastPage {
+			return false
+		}
+		it.err = it.nextPage()
+		if it.err != nil {
+			return false
+		}
+	}
+}
+
+func (it *S3ObjectIterator) Object() S3Object {
+	return it.current
+}
+
+func (it *S3ObjectIterator) Err() error {
+	return it.err
+}
+
+func (it *S3ObjectIterator) nextPage() (err error) {
+	defer wrapS3Error(&err, "ListObjectsV2", it.prefix.Bucket, it.prefix.Prefix)
+
+	if it.s3Session == nil {
+		s3Session, err := it.prefix.s3Client()
+		if err != nil {
+			return err
+		}
+		it.s3Session = s3Session
+	}
+
+	output, err := it.s3Session.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:            aws.String(it.prefix.Bucket),
+		Prefix:            aws.String(it.prefix.Prefix),
+		ContinuationToken: it.continuationToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	it.page = output.Contents
+	it.pageIndex = 0
+	it.continuationToken = output.NextContinuationToken
+	it.lastPage = !aws.ToBool(output.IsTruncated) || output.NextContinuationToken == nil
+
+	return nil
+}
+
+func newS3ObjectFromListing(prefix S3ObjectPrefix, object types.Object) S3Object {
+	return S3Object{
+		ServiceKey:   prefix.ServiceKey,
+		Bucket:       prefix.Bucket,
+		ObjectKey:    aws.ToString(object.Key),
+		Exists:       true,
+		ETag:         strings.ReplaceAll(aws.ToString(object.ETag), "\"", ""),
+		Size:         aws.ToInt64(object.Size),
+		StorageClass: string(object.StorageClass),
+		LastModified: aws.ToTime(object.LastModified),
+		RetryPolicy:  prefix.RetryPolicy,
+		RoleChain:    prefix.RoleChain,
+		Client:       prefix.Client,
+	}
+}
@@ -0,0 +1,318 @@
+package s3utils
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// S3Location identifies an object or prefix in a bucket. For access points Bucket holds the access point ARN,
+// which the S3 API accepts in place of a bucket name.
+type S3Location struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Region string `json:"region,omitempty"` // Only known when it is part of the parsed URL
+}
+
+var accessPointAliasPattern = regexp.MustCompile(`^(.+)-(\d{12})$`)
+
+// ParseS3Location parses an S3 location from any of the following forms:
+//
+//	s3://bucket/key
+//	https://bucket.s3.region.amazonaws.com/key (virtual-hosted)
+//	https://s3.region.amazonaws.com/bucket/key (path-style)
+//	https://name-account.s3-accesspoint.region.amazonaws.com/key
+//	arn:aws:s3:region:account:accesspoint/name/object/key
+//	arn:aws:s3:::bucket/key
+//	https://s3.console.aws.amazon.com/s3/object/bucket?region=region&prefix=key
+//
+// Keys in s3:// and https URLs are percent-decoded, so that s3://bucket/a%20b names the key "a b". An s3:// key that
+// is not valid percent-encoding, such as s3://bucket/100% done.txt, is taken literally as the AWS CLI does. Keys in
+// ARNs are taken literally.
+func ParseS3Location(rawUrl string) (S3Location, error) {
+	switch {
+	case strings.HasPrefix(rawUrl, "s3://"):
+		return parseS3Uri(strings.TrimPrefix(rawUrl, "s3://"))
+	case strings.HasPrefix(rawUrl, "arn:"):
+		return parseS3Arn(rawUrl)
+	case strings.HasPrefix(rawUrl, "https://"), strings.HasPrefix(rawUrl, "http://"):
+		return parseS3HttpUrl(rawUrl)
+	}
+
+	return S3Location{}, errors.New("invalid S3 URL: '" + rawUrl + "' must be an s3:// URL, an https URL or an S3 ARN")
+}
+
+func parseS3Uri(path string) (S3Location, error) {
+	if strings.HasPrefix(path, "arn:") {
+		// The AWS CLI form s3://<access point ARN>/key has no object/ element before the key
+		index := strings.Index(path, ":accesspoint/")
+		if index == -1 {
+			return S3Location{}, errors.New("invalid S3 URL: only access point ARNs may be used in s3:// URLs")
+		}
+		index += len(":accesspoint/")
+		tokens := strings.SplitN(path[index:], "/", 2)
+		location, err := parseS3Arn(path[:index] + tokens[0])
+		if err != nil {
+			return S3Location{}, err
+		}
+		if len(tokens) == 2 {
+			location.Key = unescapeS3UriKey(tokens[1])
+		}
+		return location, nil
+	}
+
+	tokens := strings.SplitN(path, "/", 2)
+	if tokens[0] == "" {
+		return S3Location{}, errors.New("invalid S3 URL: missing bucket. S3 URL must be in the form of s3://bucket_name/object_key")
+	}
+
+	location := S3Location{Bucket: tokens[0]}
+	if len(tokens) == 2 {
+		location.Key = unescapeS3UriKey(tokens[1])
+	}
+	return location, nil
+}
+
+// unescapeS3UriKey percent-decodes the key, or returns it unchanged when it is not valid percent-encoding
+func unescapeS3UriKey(key string) string {
+	unescapedKey, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+	return unescapedKey
+}
+
+func parseS3Arn(arn string) (S3Location, error) {
+	// arn:partition:s3:region:account:resource
+	tokens := strings.SplitN(arn, ":", 6)
+	if len(tokens) != 6 || tokens[2] != "s3" || tokens[5] == "" {
+		return S3Location{}, errors.New("invalid S3 ARN: '" + arn + "' must be in the form of arn:aws:s3:region:account:resource")
+	}
+	partition, region, account, resource := tokens[1], tokens[3], tokens[4], tokens[5]
+
+	if strings.HasPrefix(resource, "accesspoint/") {
+		resourceTokens := strings.SplitN(strings.TrimPrefix(resource, "accesspoint/"), "/", 2)
+		if resourceTokens[0] == "" || region == "" || account == "" {
+			return S3Location{}, errors.New("invalid S3 ARN: access point ARN '" + arn + "' must include region, account and name")
+		}
+
+		location := S3Location{
+			Bucket: accessPointArn(partition, region, account, resourceTokens[0]),
+			Region: region,
+		}
+		if len(resourceTokens) == 2 {
+			if !strings.HasPrefix(resourceTokens[1], "object/") {
+				return S3Location{}, errors.New("invalid S3 ARN: access point object ARN '" + arn + "' must be in the form of .../accesspoint/name/object/key")
+			}
+			location.Key = strings.TrimPrefix(resourceTokens[1], "object/")
+		}
+		return location, nil
+	}
+
+	if region != "" || account != "" {
+		return S3Location{}, errors.New("invalid S3 ARN: unsupported resource in '" + arn + "'")
+	}
+	return parseS3Uri(resource)
+}
+
+func parseS3HttpUrl(rawUrl string) (S3Location, error) {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return S3Location{}, errors.New("invalid S3 URL: " + err.Error())
+	}
+
+	host := strings.ToLower(parsedUrl.Hostname())
+	if host == "console.aws.amazon.com" || strings.HasSuffix(host, ".console.aws.amazon.com") {
+		return parseS3ConsoleUrl(parsedUrl)
+	}
+
+	partition := "aws"
+	domain := ".amazonaws.com"
+	if strings.HasSuffix(host, ".amazonaws.com.cn") {
+		partition = "aws-cn"
+		domain = ".amazonaws.com.cn"
+	}
+	if !strings.HasSuffix(host, domain) {
+		return S3Location{}, errors.New("invalid S3 URL: unrecognized S3 host '" + host + "'")
+	}
+
+	// Bucket names may contain dots, so the endpoint is found from the last s3 label of the host
+	labels := strings.Split(strings.TrimSuffix(host, domain), ".")
+	endpointIndex := -1
+	for i := len(labels) - 1; i >= 0; i-- {
+		if labels[i] == "s3" || strings.HasPrefix(labels[i], "s3-") {
+			endpointIndex = i
+			break
+		}
+	}
+	if endpointIndex == -1 {
+		return S3Location{}, errors.New("invalid S3 URL: unrecognized S3 host '" + host + "'")
+	}
+	endpoint, bucket := labels[endpointIndex], strings.Join(labels[:endpointIndex], ".")
+	region := s3EndpointRegion(endpoint, labels[endpointIndex+1:])
+	path := strings.TrimPrefix(parsedUrl.Path, "/")
+
+	if endpoint == "s3-accesspoint" {
+		matches := accessPointAliasPattern.FindStringSubmatch(bucket)
+		if matches == nil || region == "" {
+			return S3Location{}, errors.New("invalid S3 URL: unrecognized access point host '" + host + "'")
+		}
+		return S3Location{
+			Bucket: accessPointArn(partition, region, matches[2], matches[1]),
+			Key:    path,
+			Region: region,
+		}, nil
+	}
+
+	if bucket == "" {
+		// Path-style URL
+		tokens := strings.SplitN(path, "/", 2)
+		if tokens[0] == "" {
+			return S3Location{}, errors.New("invalid S3 URL: missing bucket in path-style URL '" + rawUrl + "'")
+		}
+		bucket = tokens[0]
+		path = ""
+		if len(tokens) == 2 {
+			path = tokens[1]
+		}
+	}
+
+	return S3Location{
+		Bucket: bucket,
+		Key:    path,
+		Region: region,
+	}, nil
+}
+
+// s3EndpointRegion returns the region of an S3 endpoint such as s3.us-west-2, s3.dualstack.us-west-2 or the legacy
+// s3-us-west-2, or an empty string for the global endpoint
+func s3EndpointRegion(endpoint string, remainingLabels []string) string {
+	switch {
+	case endpoint == "s3-external-1":
+		return "us-east-1"
+	case strings.HasPrefix(endpoint, "s3-website-"):
+		return strings.TrimPrefix(endpoint, "s3-website-")
+	case endpoint != "s3" && endpoint != "s3-accesspoint" && endpoint != "s3-website" && endpoint != "s3-fips":
+		return strings.TrimPrefix(endpoint, "s3-")
+	}
+
+	for _, label := range remainingLabels {
+		if label != "dualstack" && label != "s3-control" {
+			return label
+		}
+	}
+	return ""
+}
+
+func parseS3ConsoleUrl(parsedUrl *url.URL) (S3Location, error) {
+	path := strings.TrimPrefix(parsedUrl.Path, "/s3")
+	var bucket string
+	switch {
+	case strings.HasPrefix(path, "/object/"):
+		bucket = strings.TrimPrefix(path, "/object/")
+	case strings.HasPrefix(path, "/buckets/"):
+		bucket = strings.TrimPrefix(path, "/buckets/")
+	default:
+		return S3Location{}, errors.New("invalid S3 URL: unrecognized S3 console URL '" + parsedUrl.String() + "'")
+	}
+	bucket = strings.TrimSuffix(bucket, "/")
+	if bucket == "" || strings.Contains(bucket, "/") {
+		return S3Location{}, errors.New("invalid S3 URL: missing bucket in S3 console URL '" + parsedUrl.String() + "'")
+	}
+
+	query := parsedUrl.Query()
+	return S3Location{
+		Bucket: bucket,
+		Key:    query.Get("prefix"),
+		Region: query.Get("region"),
+	}, nil
+}
+
+func accessPointArn(partition string, region string, account string, name string) string {
+	return "arn:" + partition + ":s3:" + region + ":" + account + ":accesspoint/" + name
+}
+
+// IsAccessPoint reports whether the location refers to an access point rather than a bucket
+func (l S3Location) IsAccessPoint() bool {
+	return strings.HasPrefix(l.Bucket, "arn:")
+}
+
+// S3Url formats the location as an s3:// URL with the key percent-encoded, so that ParseS3Location returns the same
+// key. Access points are formatted as s3://<access point ARN>/key, which the AWS CLI accepts.
+func (l S3Location) S3Url() string {
+	return "s3://" + l.Bucket + "/" + escapeKey(l.Key)
+}
+
+// HttpsUrl formats the location as a virtual-hosted https URL, or a path-style URL for bucket names containing dots
+// which cannot be used with the S3 TLS certificate
+func (l S3Location) HttpsUrl() string {
+	path := escapeKey(l.Key)
+
+	if l.IsAccessPoint() {
+		tokens := strings.SplitN(l.Bucket, ":", 6)
+		domain := ".amazonaws.com"
+		if tokens[1] == "aws-cn" {
+			domain = ".amazonaws.com.cn"
+		}
+		name := strings.TrimPrefix(tokens[5], "accesspoint/")
+		return "https://" + name + "-" + tokens[4] + ".s3-accesspoint." + tokens[3] + domain + "/" + path
+	}
+
+	endpoint := "s3.amazonaws.com"
+	if l.Region != "" {
+		endpoint = "s3." + l.Region + ".amazonaws.com"
+		if strings.HasPrefix(l.Region, "cn-") {
+			endpoint += ".cn"
+		}
+	}
+	if strings.Contains(l.Bucket, ".") {
+		return "https://" + endpoint + "/" + l.Bucket + "/" + path
+	}
+	return "https://" + l.Bucket + "." + endpoint + "/" + path
+}
+
+// ConsoleUrl formats the location as a link to the object, or to the prefix when the key is empty or ends in "/",
+// in the S3 console
+func (l S3Location) ConsoleUrl() string {
+	query := url.Values{}
+	query.Set("prefix", l.Key)
+	if l.Region != "" {
+		query.Set("region", l.Region)
+	}
+
+	view := "object"
+	if l.Key == "" || strings.HasSuffix(l.Key, "/") {
+		view = "buckets"
+	}
+	return "https://s3.console.aws.amazon.com/s3/" + view + "/" + l.Bucket + "?" + query.Encode()
+}
+
+// escapeKey percent-encodes each segment of the key, keeping the "/" separators
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+// String formats the location as an s3:// URL with the key as is, the way the AWS CLI prints it
+func (l S3Location) String() string {
+	return "s3://" + l.Bucket + "/" + l.Key
+}
+
+func (s *S3Object) Location() S3Location {
+	return S3Location{
+		Bucket: s.Bucket,
+		Key:    s.ObjectKey,
+		Region: s.Region,
+	}
+}
+
+func (s *S3ObjectPrefix) Location() S3Location {
+	return S3Location{
+		Bucket: s.Bucket,
+		Key:    s.Prefix,
+	}
+}
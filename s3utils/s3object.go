@@ -2,79 +2,129 @@ package s3utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/tnyidea/awsutils-go/awsutils"
+	"hash"
 	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
-	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type S3Object struct {
-	ServiceKey   string    `json:"-"` // Should be private for output
-	Region       string    `json:"region"`
-	Bucket       string    `json:"bucket"`
-	ObjectKey    string    `json:"objectKey"`
-	Exists       bool      `json:"exists"`
-	ETag         string    `json:"etag"`
-	Size         int64     `json:"size"`
-	StorageClass string    `json:"storageClass"`
-	LastModified time.Time `json:"lastModified"`
+	ServiceKey   string            `json:"-"` // Should be private for output
+	Region       string            `json:"region"`
+	Bucket       string            `json:"bucket"`
+	ObjectKey    string            `json:"objectKey"`
+	Exists       bool              `json:"exists"`
+	ETag         string            `json:"etag"`
+	Size         int64             `json:"size"`
+	StorageClass string            `json:"storageClass"`
+	LastModified time.Time         `json:"lastModified"`
+	Checksums    map[string]string `json:"checksums,omitempty"` // Keyed by checksum algorithm, loaded by GetChecksums
+
+	RetryPolicy *awsutils.RetryPolicy `json:"-"` // SDK default retries when nil
+	RoleChain   []awsutils.AssumeRole `json:"-"` // Roles assumed in order starting from ServiceKey
+	Client      S3Client              `json:"-"` // Created from ServiceKey, RetryPolicy and RoleChain when nil
 }
 
 func NewS3Object(bucket string, objectKey string, serviceKey string) (S3Object, error) {
+	return NewS3ObjectFromLocation(S3Location{
+		Bucket: bucket,
+		Key:    objectKey,
+	}, serviceKey)
+}
+
+// NewS3ObjectFromLocation creates an S3Object for the location, looking up the bucket region unless the location
+// already includes it
+func NewS3ObjectFromLocation(location S3Location, serviceKey string) (S3Object, error) {
+	return newS3ObjectFromLocation(location, serviceKey, nil)
+}
+
+// NewS3ObjectWithRoleChain creates an S3Object accessed with the credentials of the last role in the chain, such
+// as a role in another account that owns the bucket
+func NewS3ObjectWithRoleChain(bucket string, objectKey string, serviceKey string, roleChain []awsutils.AssumeRole) (S3Object, error) {
+	return newS3ObjectFromLocation(S3Location{
+		Bucket: bucket,
+		Key:    objectKey,
+	}, serviceKey, roleChain)
+}
+
+func newS3ObjectFromLocation(location S3Location, serviceKey string, roleChain []awsutils.AssumeRole) (S3Object, error) {
 	s3Object := S3Object{
 		ServiceKey: serviceKey,
-		Bucket:     bucket,
-		ObjectKey:  objectKey,
+		Region:     location.Region,
+		Bucket:     location.Bucket,
+		ObjectKey:  location.Key,
 		Exists:     true,
+		RoleChain:  roleChain,
 	}
 
-	region, err := getBucketRegion(s3Object.Bucket, serviceKey)
-	if err != nil {
-		return S3Object{}, errors.New("error locating bucket region: " + err.Error())
+	if s3Object.Region == "" {
+		region, err := getBucketRegion(s3Object.Bucket, serviceKey, nil, roleChain)
+		if err != nil {
+			return S3Object{}, err
+		}
+		s3Object.Region = region
+	}
+
+	return loadS3Object(s3Object)
+}
+
+// NewS3ObjectWithClient creates an S3Object that sends its requests through the client instead of one created from
+// a service key. The bucket region is not looked up, since the client already determines it.
+func NewS3ObjectWithClient(bucket string, objectKey string, client S3Client) (S3Object, error) {
+	s3Object := S3Object{
+		Bucket:    bucket,
+		ObjectKey: objectKey,
+		Exists:    true,
+		Client:    client,
 	}
-	s3Object.Region = region
-	s3Object.localizeServiceKey()
 
-	err = s3Object.listObjectV2()
+	return loadS3Object(s3Object)
+}
+
+// loadS3Object fills in the listing attributes of the object, marking it as not existing when there is no such key
+func loadS3Object(s3Object S3Object) (S3Object, error) {
+	err := s3Object.listObjectV2()
 	if err != nil {
-		if awsError, defined := err.(awserr.Error); defined {
-			code := awsError.Code()
-			if code == s3.ErrCodeNoSuchKey {
-				s3Object.Exists = false
-				return s3Object, nil
-			}
+		if awsErrorCode(err) == "NoSuchKey" {
+			s3Object.Exists = false
+			return s3Object, nil
+		}
+		return S3Object{}, &S3Error{
+			Op:     "ListObjectsV2",
+			Bucket: s3Object.Bucket,
+			Key:    s3Object.ObjectKey,
+			Err:    err,
 		}
-		return S3Object{}, err
 	}
 
 	return s3Object, nil
 }
 
+// NewS3ObjectFromS3Url creates an S3Object from any URL form accepted by ParseS3Location
 func NewS3ObjectFromS3Url(url string, serviceKey string) (S3Object, error) {
-	tokens := strings.Split(url, "//")
-	if tokens[0] != "s3:" {
-		return S3Object{}, errors.New("invalid S3 URL: invalid protocol '" + tokens[0] +
-			"'. S3 URL Must be in the form of s3://bucket_name/object_key")
+	location, err := ParseS3Location(url)
+	if err != nil {
+		return S3Object{}, err
 	}
-
-	tokens = strings.Split(tokens[1], "/")
-	if len(tokens) == 1 {
-		return S3Object{}, errors.New("invalid S3 URL: missing object key or bucket. S3 URL Must be in the form of s3://bucket_name/object_key")
+	if location.Key == "" {
+		return S3Object{}, errors.New("invalid S3 URL: missing object key. S3 URL must be in the form of s3://bucket_name/object_key")
 	}
 
-	return NewS3Object(tokens[0], strings.Join(tokens[1:], "/"), serviceKey)
+	return NewS3ObjectFromLocation(location, serviceKey)
 }
 
 func (s *S3Object) Bytes() []byte {
@@ -82,7 +132,8 @@ func (s *S3Object) Bytes() []byte {
 	return b
 }
 
-func (s *S3Object) String() string {
+// String has a value receiver so that printing a copy of the object uses it too, which leaves out the service key
+func (s S3Object) String() string {
 	b, _ := json.MarshalIndent(s, "", "    ")
 	return string(b)
 }
@@ -97,51 +148,81 @@ func (s *S3Object) S3Url() (string, error) {
 		return "", errors.New("invalid S3 URL: must specify both Bucket and Object Key")
 	}
 
-	return "s3://" + s.Bucket + "/" + s.ObjectKey, nil
+	return s.Location().String(), nil
+}
+
+// sharesCredentials reports whether requests for the object and the target are sent with the same credentials, so
+// that the object can be copied to the target server side. The region does not matter, since CopyObject sent to the
+// region of the target reads the source from any region.
+func (s *S3Object) sharesCredentials(target S3Object) bool {
+	if s.ServiceKey != target.ServiceKey || !sameClient(s.Client, target.Client) ||
+		len(s.RoleChain) != len(target.RoleChain) {
+		return false
+	}
+	for i := range s.RoleChain {
+		if s.RoleChain[i].RoleArn != target.RoleChain[i].RoleArn || s.RoleChain[i].ExternalId != target.RoleChain[i].ExternalId {
+			return false
+		}
+	}
+	return true
+}
+
+// sameClient reports whether two injected clients are the same pointer. Other clients are treated as distinct, since
+// comparing interface values with == panics when their dynamic type is not comparable.
+func sameClient(a S3Client, b S3Client) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	aValue, bValue := reflect.ValueOf(a), reflect.ValueOf(b)
+	if aValue.Type() != bValue.Type() || aValue.Kind() != reflect.Ptr {
+		return false
+	}
+	return aValue.Pointer() == bValue.Pointer()
 }
 
-func (s *S3Object) localizeServiceKey() {
-	tokens := strings.Split(s.ServiceKey, ":")
-	tokens[0] = s.Region
-	s.ServiceKey = strings.Join(tokens, ":")
+// copySource returns the object as the CopySource of a CopyObject or UploadPartCopy request, which S3 URL decodes
+func (s *S3Object) copySource() string {
+	return "/" + s.Bucket + "/" + escapeKey(s.ObjectKey)
 }
 
 func (s *S3Object) listObjectV2() error {
-	s3Session, err := NewS3Session(s.ServiceKey)
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return err
 	}
 
-	output, err := s3Session.ListObjectsV2(&s3.ListObjectsV2Input{
+	output, err := s3Session.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
 		Bucket:  aws.String(s.Bucket),
-		MaxKeys: aws.Int64(1),
+		MaxKeys: aws.Int32(1),
 		Prefix:  aws.String(s.ObjectKey),
 	})
 	if err != nil {
 		return err
 	}
 
-	if *output.KeyCount != 1 {
-		return awserr.New(s3.ErrCodeNoSuchKey, "No Such Key", errors.New("no such key found"))
+	if aws.ToInt32(output.KeyCount) != 1 {
+		return &types.NoSuchKey{Message: aws.String("no such key found")}
 	}
 	object := output.Contents[0]
 
-	s.ETag = strings.ReplaceAll(*object.ETag, "\"", "")
-	s.Size = *object.Size
+	s.ETag = strings.ReplaceAll(aws.ToString(object.ETag), "\"", "")
+	s.Size = aws.ToInt64(object.Size)
 	// s.Owner = *object.Owner.DisplayName
-	s.StorageClass = *object.StorageClass
-	s.LastModified = *object.LastModified
+	s.StorageClass = string(object.StorageClass)
+	s.LastModified = aws.ToTime(object.LastModified)
 
 	return nil
 }
 
-func (s *S3Object) Copy(target S3Object) error {
-	s3Session, err := NewS3Session(s.ServiceKey)
+func (s *S3Object) Copy(target S3Object) (err error) {
+	defer wrapS3Error(&err, "CopyObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := target.s3Client()
 	if err != nil {
 		return err
 	}
-	_, err = s3Session.CopyObject(&s3.CopyObjectInput{
-		CopySource: aws.String("/" + s.Bucket + "/" + s.ObjectKey),
+	_, err = s3Session.CopyObject(context.Background(), &s3.CopyObjectInput{
+		CopySource: aws.String(s.copySource()),
 		Bucket:     aws.String(target.Bucket),
 		Key:        aws.String(target.ObjectKey),
 	})
@@ -152,18 +233,32 @@ func (s *S3Object) Copy(target S3Object) error {
 	return nil
 }
 
-func (s *S3Object) MultipartCopy(target S3Object) error {
-	source := s
-	if (source.ServiceKey != target.ServiceKey) || source.Region != target.Region {
-		return s.crossRegionMultipartCopy(target)
+func (s *S3Object) MultipartCopy(target S3Object) (err error) {
+	defer wrapS3Error(&err, "MultipartCopy", s.Bucket, s.ObjectKey)
+
+	if !s.sharesCredentials(target) {
+		return s.crossRegionMultipartCopy(target, "", false)
 	}
 
-	s3Session, err := NewS3Session(s.ServiceKey)
+	return s.multipartCopy(target, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(target.ObjectKey),
+	})
+}
+
+func (s *S3Object) multipartCopy(target S3Object, createInput *s3.CreateMultipartUploadInput) error {
+	source := s
+
+	sourceSession, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+	s3Session, err := target.s3Client()
 	if err != nil {
 		return err
 	}
 
-	sourceHeadObjectResult, err := s3Session.HeadObject(&s3.HeadObjectInput{
+	sourceHeadObjectResult, err := sourceSession.HeadObject(context.Background(), &s3.HeadObjectInput{
 		Bucket: aws.String(source.Bucket),
 		Key:    aws.String(source.ObjectKey),
 	})
@@ -171,15 +266,17 @@ func (s *S3Object) MultipartCopy(target S3Object) error {
 		return err
 	}
 
-	sourceObjectSize := *sourceHeadObjectResult.ContentLength
+	sourceObjectSize := aws.ToInt64(sourceHeadObjectResult.ContentLength)
 	// partSize := int64(math.Pow(1024, 3)) // 1 GiB
 	partSize := int64(math.Pow(1024, 2) * 100) // 100 MiB
-	partNumber := int64(1)
+	partNumber := int32(1)
 
-	uploader, err := s3Session.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
-		Bucket: aws.String(target.Bucket),
-		Key:    aws.String(target.ObjectKey),
-	})
+	checksumAlgorithm := string(createInput.ChecksumAlgorithm)
+	if checksumAlgorithm == ChecksumAlgorithmCRC64NVME {
+		return errors.New("invalid checksum algorithm: " + checksumAlgorithm + " is not supported for multipart copies")
+	}
+
+	uploader, err := s3Session.CreateMultipartUpload(context.Background(), createInput)
 	if err != nil {
 		return err
 	}
@@ -188,40 +285,44 @@ func (s *S3Object) MultipartCopy(target S3Object) error {
 	log.Println("Source File Size:", sourceObjectSize)
 	log.Println("Part Size:", partSize)
 
-	var completedParts []*s3.CompletedPart
+	var completedParts []types.CompletedPart
 	for bytePosition := int64(0); bytePosition < sourceObjectSize; bytePosition += partSize {
 		lastByte := int64(math.Min(float64(bytePosition+partSize-1), float64(sourceObjectSize-1)))
 		byteRangeString := "bytes=" + strconv.FormatInt(bytePosition, 10) + "-" + strconv.FormatInt(lastByte, 10)
 		log.Println("Copying Part Number", partNumber, ": Byte Range:", byteRangeString)
 
-		partResult, err := s3Session.UploadPartCopy(&s3.UploadPartCopyInput{
+		partResult, err := s3Session.UploadPartCopy(context.Background(), &s3.UploadPartCopyInput{
 			Bucket:          aws.String(target.Bucket),
-			CopySource:      aws.String(url.PathEscape("/" + source.Bucket + "/" + source.ObjectKey)),
+			CopySource:      aws.String(source.copySource()),
 			CopySourceRange: aws.String(byteRangeString),
 			Key:             aws.String(target.ObjectKey),
-			PartNumber:      aws.Int64(partNumber),
+			PartNumber:      aws.Int32(partNumber),
 			UploadId:        uploader.UploadId,
 		})
 		if err != nil {
+			abortMultipartUpload(s3Session, uploader)
 			return err
 		}
 
-		completedParts = append(completedParts, &s3.CompletedPart{
+		completedPart := types.CompletedPart{
 			ETag:       partResult.CopyPartResult.ETag,
-			PartNumber: aws.Int64(partNumber),
-		})
+			PartNumber: aws.Int32(partNumber),
+		}
+		setCompletedPartChecksum(&completedPart, checksumAlgorithm, copyPartChecksum(partResult.CopyPartResult, checksumAlgorithm))
+		completedParts = append(completedParts, completedPart)
 		partNumber++
 	}
 
-	_, err = s3Session.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+	_, err = s3Session.CompleteMultipartUpload(context.Background(), &s3.CompleteMultipartUploadInput{
 		Bucket: aws.String(target.Bucket),
 		Key:    aws.String(target.ObjectKey),
-		MultipartUpload: &s3.CompletedMultipartUpload{
+		MultipartUpload: &types.CompletedMultipartUpload{
 			Parts: completedParts,
 		},
 		UploadId: uploader.UploadId,
 	})
 	if err != nil {
+		abortMultipartUpload(s3Session, uploader)
 		return err
 	}
 
@@ -229,19 +330,31 @@ func (s *S3Object) MultipartCopy(target S3Object) error {
 	return nil
 }
 
-func (s *S3Object) crossRegionMultipartCopy(target S3Object) error {
+// crossRegionMultipartCopy streams the object through this process. When checksumAlgorithm is set a checksum is
+// sent with every part so the target can validate the data it receives. The storage class and encryption algorithm
+// of the source are kept, but not its KMS key, which belongs to the account and region of the source.
+func (s *S3Object) crossRegionMultipartCopy(target S3Object, checksumAlgorithm string, recordSourceETag bool) error {
 	source := s
 
-	sourceSession, err := NewS3Session(s.ServiceKey)
+	var fullObjectHash hash.Hash
+	if checksumAlgorithm != "" {
+		var err error
+		fullObjectHash, err = newChecksumHash(checksumAlgorithm)
+		if err != nil {
+			return err
+		}
+	}
+
+	sourceSession, err := s.s3Client()
 	if err != nil {
 		return err
 	}
-	targetSession, err := NewS3Session(target.ServiceKey)
+	targetSession, err := target.s3Client()
 	if err != nil {
 		return err
 	}
 
-	sourceHeadObjectResult, err := sourceSession.HeadObject(&s3.HeadObjectInput{
+	sourceHeadObjectResult, err := sourceSession.HeadObject(context.Background(), &s3.HeadObjectInput{
 		Bucket: aws.String(source.Bucket),
 		Key:    aws.String(source.ObjectKey),
 	})
@@ -249,20 +362,56 @@ func (s *S3Object) crossRegionMultipartCopy(target S3Object) error {
 		return err
 	}
 
-	sourceObjectSize := *sourceHeadObjectResult.ContentLength
+	sourceObjectSize := aws.ToInt64(sourceHeadObjectResult.ContentLength)
+	if sourceObjectSize == 0 {
+		// A multipart upload needs at least one part, so empty objects are written directly
+		putObjectInput := &s3.PutObjectInput{
+			Body:                    bytes.NewReader([]byte{}),
+			Bucket:                  aws.String(target.Bucket),
+			Key:                     aws.String(target.ObjectKey),
+			CacheControl:            sourceHeadObjectResult.CacheControl,
+			ContentDisposition:      sourceHeadObjectResult.ContentDisposition,
+			ContentEncoding:         sourceHeadObjectResult.ContentEncoding,
+			ContentLanguage:         sourceHeadObjectResult.ContentLanguage,
+			ContentType:             sourceHeadObjectResult.ContentType,
+			Metadata:                copyMetadata(sourceHeadObjectResult, recordSourceETag),
+			WebsiteRedirectLocation: sourceHeadObjectResult.WebsiteRedirectLocation,
+			StorageClass:            sourceHeadObjectResult.StorageClass,
+			ServerSideEncryption:    sourceHeadObjectResult.ServerSideEncryption,
+		}
+		if checksumAlgorithm != "" {
+			putObjectInput.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+		}
+		_, err = targetSession.PutObject(context.Background(), putObjectInput)
+		return err
+	}
+
 	// partSize := int64(math.Pow(1024, 3)) // 1 GiB
 	partSize := int64(math.Pow(1024, 2) * 100) // 100 MiB
-	partNumber := int64(1)
-
-	downloader := s3manager.NewDownloaderWithClient(sourceSession,
-		func(d *s3manager.Downloader) {
-			d.PartSize = partSize
-		})
+	partNumber := int32(1)
 
-	uploader, err := targetSession.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
-		Bucket: aws.String(target.Bucket),
-		Key:    aws.String(target.ObjectKey),
+	downloader := manager.NewDownloader(sourceSession, func(d *manager.Downloader) {
+		d.PartSize = partSize
 	})
+
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket:                  aws.String(target.Bucket),
+		Key:                     aws.String(target.ObjectKey),
+		CacheControl:            sourceHeadObjectResult.CacheControl,
+		ContentDisposition:      sourceHeadObjectResult.ContentDisposition,
+		ContentEncoding:         sourceHeadObjectResult.ContentEncoding,
+		ContentLanguage:         sourceHeadObjectResult.ContentLanguage,
+		ContentType:             sourceHeadObjectResult.ContentType,
+		Metadata:                copyMetadata(sourceHeadObjectResult, recordSourceETag),
+		WebsiteRedirectLocation: sourceHeadObjectResult.WebsiteRedirectLocation,
+		StorageClass:            sourceHeadObjectResult.StorageClass,
+		ServerSideEncryption:    sourceHeadObjectResult.ServerSideEncryption,
+	}
+	if checksumAlgorithm != "" {
+		createInput.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+		createInput.ChecksumType = checksumType(checksumAlgorithm)
+	}
+	uploader, err := targetSession.CreateMultipartUpload(context.Background(), createInput)
 	if err != nil {
 		return err
 	}
@@ -271,51 +420,63 @@ func (s *S3Object) crossRegionMultipartCopy(target S3Object) error {
 	log.Println("Source File Size:", sourceObjectSize)
 	log.Println("Part Size:", partSize)
 
-	var completedParts []*s3.CompletedPart
-	var buffer []byte
-	writeBuffer := aws.NewWriteAtBuffer(buffer)
+	var completedParts []types.CompletedPart
 	for bytePosition := int64(0); bytePosition < sourceObjectSize; bytePosition += partSize {
 		lastByte := int64(math.Min(float64(bytePosition+partSize-1), float64(sourceObjectSize-1)))
 		byteRangeString := "bytes=" + strconv.FormatInt(bytePosition, 10) + "-" + strconv.FormatInt(lastByte, 10)
 		log.Println("Copying Part Number", partNumber, ": Byte Range:", byteRangeString)
 
-		_, err := downloader.Download(writeBuffer, &s3.GetObjectInput{
+		// Use a fresh buffer for each part so a short final part does not carry bytes from the previous one
+		writeBuffer := manager.NewWriteAtBuffer(make([]byte, 0, lastByte-bytePosition+1))
+		_, err := downloader.Download(context.Background(), writeBuffer, &s3.GetObjectInput{
 			Bucket: aws.String(source.Bucket),
 			Key:    aws.String(source.ObjectKey),
 			Range:  aws.String(byteRangeString),
 		})
 		if err != nil {
+			abortMultipartUpload(targetSession, uploader)
 			return err
 		}
 
-		partResult, err := targetSession.UploadPart(&s3.UploadPartInput{
+		uploadPartInput := &s3.UploadPartInput{
 			Body:          bytes.NewReader(writeBuffer.Bytes()),
 			Bucket:        aws.String(target.Bucket),
-			ContentLength: aws.Int64(partSize),
+			ContentLength: aws.Int64(int64(len(writeBuffer.Bytes()))),
 			Key:           aws.String(target.ObjectKey),
-			PartNumber:    aws.Int64(partNumber),
+			PartNumber:    aws.Int32(partNumber),
 			UploadId:      uploader.UploadId,
-		})
+		}
+		if checksumAlgorithm != "" {
+			fullObjectHash.Write(writeBuffer.Bytes())
+			uploadPartInput.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+		}
+		partResult, err := targetSession.UploadPart(context.Background(), uploadPartInput)
 		if err != nil {
+			abortMultipartUpload(targetSession, uploader)
 			return err
 		}
 
-		completedParts = append(completedParts, &s3.CompletedPart{
+		completedPart := types.CompletedPart{
 			ETag:       partResult.ETag,
-			PartNumber: aws.Int64(partNumber),
-		})
+			PartNumber: aws.Int32(partNumber),
+		}
+		setCompletedPartChecksum(&completedPart, checksumAlgorithm, uploadPartChecksum(partResult, checksumAlgorithm))
+		completedParts = append(completedParts, completedPart)
 		partNumber++
 	}
 
-	_, err = targetSession.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+	completeInput := &s3.CompleteMultipartUploadInput{
 		Bucket: aws.String(target.Bucket),
 		Key:    aws.String(target.ObjectKey),
-		MultipartUpload: &s3.CompletedMultipartUpload{
+		MultipartUpload: &types.CompletedMultipartUpload{
 			Parts: completedParts,
 		},
 		UploadId: uploader.UploadId,
-	})
+	}
+	setCompleteChecksum(completeInput, checksumAlgorithm, fullObjectHash)
+	_, err = targetSession.CompleteMultipartUpload(context.Background(), completeInput)
 	if err != nil {
+		abortMultipartUpload(targetSession, uploader)
 		return err
 	}
 
@@ -323,13 +484,25 @@ func (s *S3Object) crossRegionMultipartCopy(target S3Object) error {
 	return nil
 }
 
-func (s *S3Object) Delete() error {
-	s3Session, err := NewS3Session(s.ServiceKey)
+// abortMultipartUpload discards the parts of a failed upload, which would otherwise be stored and billed until
+// they are aborted
+func abortMultipartUpload(s3Session S3Client, uploader *s3.CreateMultipartUploadOutput) {
+	_, _ = s3Session.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   uploader.Bucket,
+		Key:      uploader.Key,
+		UploadId: uploader.UploadId,
+	})
+}
+
+func (s *S3Object) Delete() (err error) {
+	defer wrapS3Error(&err, "DeleteObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return err
 	}
 
-	_, err = s3Session.DeleteObject(&s3.DeleteObjectInput{
+	_, err = s3Session.DeleteObject(context.Background(), &s3.DeleteObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(s.ObjectKey),
 	})
@@ -340,15 +513,17 @@ func (s *S3Object) Delete() error {
 	return nil
 }
 
-func (s *S3Object) DownloadBytes() ([]byte, error) {
-	awsSession, err := awsutils.NewAWSSession(s.ServiceKey)
+func (s *S3Object) DownloadBytes() (downloadBytes []byte, err error) {
+	defer wrapS3Error(&err, "GetObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return nil, err
 	}
 
-	s3DownloadBuffer := aws.NewWriteAtBuffer([]byte{})
-	s3Downloader := s3manager.NewDownloader(awsSession)
-	_, err = s3Downloader.Download(s3DownloadBuffer,
+	s3DownloadBuffer := manager.NewWriteAtBuffer([]byte{})
+	s3Downloader := manager.NewDownloader(s3Session)
+	_, err = s3Downloader.Download(context.Background(), s3DownloadBuffer,
 		&s3.GetObjectInput{
 			Bucket: aws.String(s.Bucket),
 			Key:    aws.String(s.ObjectKey),
@@ -360,15 +535,17 @@ func (s *S3Object) DownloadBytes() ([]byte, error) {
 	return s3DownloadBuffer.Bytes(), nil
 }
 
-func (s *S3Object) DownloadReader() (io.ReadCloser, error) {
-	awsSession, err := awsutils.NewAWSSession(s.ServiceKey)
+func (s *S3Object) DownloadReader() (reader io.ReadCloser, err error) {
+	defer wrapS3Error(&err, "GetObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return nil, err
 	}
 
-	s3DownloadBuffer := aws.NewWriteAtBuffer([]byte{})
-	s3Downloader := s3manager.NewDownloader(awsSession)
-	_, err = s3Downloader.Download(s3DownloadBuffer,
+	s3DownloadBuffer := manager.NewWriteAtBuffer([]byte{})
+	s3Downloader := manager.NewDownloader(s3Session)
+	_, err = s3Downloader.Download(context.Background(), s3DownloadBuffer,
 		&s3.GetObjectInput{
 			Bucket: aws.String(s.Bucket),
 			Key:    aws.String(s.ObjectKey),
@@ -390,14 +567,16 @@ func (s *S3Object) Rename(targetObjectKey string) error {
 	return s.Delete()
 }
 
-func (s *S3Object) UploadBytes(uploadBytes []byte) error {
-	awsSession, err := awsutils.NewAWSSession(s.ServiceKey)
+func (s *S3Object) UploadBytes(uploadBytes []byte) (err error) {
+	defer wrapS3Error(&err, "PutObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return err
 	}
 
-	s3Uploader := s3manager.NewUploader(awsSession)
-	_, err = s3Uploader.Upload(&s3manager.UploadInput{
+	s3Uploader := manager.NewUploader(s3Session)
+	_, err = s3Uploader.Upload(context.Background(), &s3.PutObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(s.ObjectKey),
 		Body:   bytes.NewReader(uploadBytes),
@@ -408,14 +587,16 @@ func (s *S3Object) UploadBytes(uploadBytes []byte) error {
 	return nil
 }
 
-func (s *S3Object) UploadReader(reader io.ReadCloser) error {
-	awsSession, err := awsutils.NewAWSSession(s.ServiceKey)
+func (s *S3Object) UploadReader(reader io.ReadCloser) (err error) {
+	defer wrapS3Error(&err, "PutObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
 	if err != nil {
 		return err
 	}
 
-	s3Uploader := s3manager.NewUploader(awsSession)
-	_, err = s3Uploader.Upload(&s3manager.UploadInput{
+	s3Uploader := manager.NewUploader(s3Session)
+	_, err = s3Uploader.Upload(context.Background(), &s3.PutObjectInput{
 		Bucket: aws.String(s.Bucket),
 		Key:    aws.String(s.ObjectKey),
 		Body:   reader,
@@ -3,17 +3,19 @@ package s3utils
 import (
 	"encoding/json"
 	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"log"
-	"strings"
+	"github.com/tnyidea/awsutils-go/awsutils"
 	"time"
 )
 
 type S3ObjectPrefix struct {
-	ServiceKey string `json:"-"` // Should be private for output
-	Bucket     string `json:"bucket"`
-	Prefix     string `json:"prefix"`
+	ServiceKey string           `json:"-"` // Should be private for output
+	Bucket     string           `json:"bucket"`
+	Prefix     string           `json:"prefix"`
+	Filters    []S3ObjectFilter `json:"-"`
+
+	RetryPolicy *awsutils.RetryPolicy `json:"-"` // SDK default retries when nil, inherited by objects listed under the prefix
+	RoleChain   []awsutils.AssumeRole `json:"-"` // Roles assumed in order starting from ServiceKey, inherited like RetryPolicy
+	Client      S3Client              `json:"-"` // Created from ServiceKey, RetryPolicy and RoleChain when nil, inherited like RetryPolicy
 }
 
 func NewS3ObjectPrefix(bucket string, prefix string, serviceKey string) (S3ObjectPrefix, error) {
@@ -24,22 +26,28 @@ func NewS3ObjectPrefix(bucket string, prefix string, serviceKey string) (S3Objec
 	}, nil
 }
 
-func NewS3ObjectPrefixFromS3Url(url string, serviceKey string) (S3ObjectPrefix, error) {
-	tokens := strings.Split(url, "//")
-	if tokens[0] != "s3:" {
-		return S3ObjectPrefix{}, errors.New("invalid S3 URL: invalid protocol '" + tokens[0] +
-			"'. S3 URL Must be in the form of s3://bucket_name/object_prefix")
-	}
+// NewS3ObjectPrefixWithClient creates an S3ObjectPrefix whose requests, and those of the objects listed under it,
+// are sent through the client instead of one created from a service key
+func NewS3ObjectPrefixWithClient(bucket string, prefix string, client S3Client) (S3ObjectPrefix, error) {
+	return S3ObjectPrefix{
+		Bucket: bucket,
+		Prefix: prefix,
+		Client: client,
+	}, nil
+}
 
-	tokens = strings.Split(tokens[1], "/")
-	if len(tokens) == 1 {
-		return S3ObjectPrefix{}, errors.New("invalid S3 URL: missing object prefix or bucket. S3 URL Must be in the form of s3://bucket_name/object_prefix")
+// NewS3ObjectPrefixFromS3Url creates an S3ObjectPrefix from any URL form accepted by ParseS3Location. A URL without
+// a key refers to the whole bucket.
+func NewS3ObjectPrefixFromS3Url(url string, serviceKey string) (S3ObjectPrefix, error) {
+	location, err := ParseS3Location(url)
+	if err != nil {
+		return S3ObjectPrefix{}, err
 	}
 
 	return S3ObjectPrefix{
 		ServiceKey: serviceKey,
-		Bucket:     tokens[0],
-		Prefix:     strings.Join(tokens[1:], "/"),
+		Bucket:     location.Bucket,
+		Prefix:     location.Key,
 	}, nil
 }
 
@@ -48,7 +56,7 @@ func (s *S3ObjectPrefix) Bytes() []byte {
 	return b
 }
 
-func (s *S3ObjectPrefix) String() string {
+func (s S3ObjectPrefix) String() string {
 	b, _ := json.MarshalIndent(s, "", "    ")
 	return string(b)
 }
@@ -58,271 +66,74 @@ func (s *S3ObjectPrefix) S3Url() (string, error) {
 		return "", errors.New("invalid S3 URL: must specify both Bucket and Object Prefix")
 	}
 
-	return "s3://" + s.Bucket + "/" + s.Prefix, nil
+	return s.Location().String(), nil
 }
 
 func (s *S3ObjectPrefix) GetTotalSize() (int64, int64, error) {
-	s3Session, err := NewS3Session(s.ServiceKey)
-	if err != nil {
-		return 0, 0, err
-	}
-
 	var count int64 = 0
 	var totalSize int64 = 0
-	var startAfter string
-	var isTruncated bool
-
-	for {
-		err := s3Session.ListObjectsV2Pages(&s3.ListObjectsV2Input{
-			Bucket:     aws.String(s.Bucket),
-			Prefix:     aws.String(s.Prefix),
-			StartAfter: aws.String(startAfter),
-		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-			if *page.KeyCount == 0 {
-				return true
-			}
-			for i := range page.Contents {
-				count++
-				totalSize += *page.Contents[i].Size
-			}
-			startAfter = *page.Contents[len(page.Contents)-1].Key
-			isTruncated = *page.IsTruncated
-
-			return false
-		})
-		if err != nil {
-			return 0, 0, err
-		}
 
-		if !isTruncated {
-			break
-		}
+	iterator := s.Iterator()
+	for iterator.Next() {
+		count++
+		totalSize += iterator.Object().Size
+	}
+	if err := iterator.Err(); err != nil {
+		return 0, 0, err
 	}
 
 	return count, totalSize, nil
 }
 
 func (s *S3ObjectPrefix) ListObjects() ([]S3Object, error) {
-	s3Session, err := NewS3Session(s.ServiceKey)
-	if err != nil {
-		return nil, err
-	}
-
-	var objectList []S3Object
-	var startAfter string
-	var isTruncated bool
-
-	for {
-		err := s3Session.ListObjectsV2Pages(&s3.ListObjectsV2Input{
-			Bucket:     aws.String(s.Bucket),
-			Prefix:     aws.String(s.Prefix),
-			FetchOwner: aws.Bool(true),
-			StartAfter: aws.String(startAfter),
-		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-			if *page.KeyCount == 0 {
-				return true
-			}
-			for i := range page.Contents {
-				pageContents := page.Contents[i]
-				objectList = append(objectList, S3Object{
-					ServiceKey:   "",
-					Bucket:       s.Bucket,
-					ObjectKey:    *pageContents.Key,
-					ETag:         strings.ReplaceAll(*page.Contents[i].ETag, "\"", ""),
-					Size:         *pageContents.Size,
-					StorageClass: *pageContents.StorageClass,
-					LastModified: *pageContents.LastModified,
-				})
-			}
-			startAfter = *page.Contents[len(page.Contents)-1].Key
-			isTruncated = *page.IsTruncated
-
-			return false
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if !isTruncated {
-			break
-		}
-	}
-
-	return objectList, nil
+	return s.listObjects()
 }
 
 func (s *S3ObjectPrefix) ListObjectsAfterTime(afterTime time.Time) ([]S3Object, error) {
-	s3Session, err := NewS3Session(s.ServiceKey)
-	if err != nil {
-		return nil, err
-	}
-
-	var objectList []S3Object
-	var startAfter string
-	var isTruncated bool
-
-	for {
-		err := s3Session.ListObjectsV2Pages(&s3.ListObjectsV2Input{
-			Bucket:     aws.String(s.Bucket),
-			Prefix:     aws.String(s.Prefix),
-			FetchOwner: aws.Bool(true),
-			StartAfter: aws.String(startAfter),
-		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-			if *page.KeyCount == 0 {
-				return true
-			}
-			for i := range page.Contents {
-				if (*page.Contents[i].LastModified).After(afterTime) {
-					pageContents := page.Contents[i]
-					objectList = append(objectList, S3Object{
-						ServiceKey:   "",
-						Bucket:       s.Bucket,
-						ObjectKey:    *pageContents.Key,
-						ETag:         strings.ReplaceAll(*page.Contents[i].ETag, "\"", ""),
-						Size:         *pageContents.Size,
-						StorageClass: *pageContents.StorageClass,
-						LastModified: *pageContents.LastModified,
-					})
-				}
-			}
-			if len(page.Contents) == 0 {
-				return true
-			}
-
-			startAfter = *page.Contents[len(page.Contents)-1].Key
-			isTruncated = *page.IsTruncated
-
-			return false
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if !isTruncated {
-			break
-		}
-	}
-
-	return objectList, nil
+	return s.listObjects(ModifiedAfter(afterTime))
 }
 
 func (s *S3ObjectPrefix) ListObjectsBeforeTime(beforeTime time.Time) ([]S3Object, error) {
-	s3Session, err := NewS3Session(s.ServiceKey)
-	if err != nil {
-		return nil, err
-	}
-
-	var objectList []S3Object
-	var startAfter string
-	var isTruncated bool
-
-	for {
-		err := s3Session.ListObjectsV2Pages(&s3.ListObjectsV2Input{
-			Bucket:     aws.String(s.Bucket),
-			Prefix:     aws.String(s.Prefix),
-			FetchOwner: aws.Bool(true),
-			StartAfter: aws.String(startAfter),
-		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-			for i := range page.Contents {
-				if (*page.Contents[i].LastModified).Before(beforeTime) {
-					pageContents := page.Contents[i]
-					objectList = append(objectList, S3Object{
-						ServiceKey:   "",
-						Bucket:       s.Bucket,
-						ObjectKey:    *pageContents.Key,
-						ETag:         strings.ReplaceAll(*page.Contents[i].ETag, "\"", ""),
-						Size:         *pageContents.Size,
-						StorageClass: *pageContents.StorageClass,
-						LastModified: *pageContents.LastModified,
-					})
-				}
-			}
-			startAfter = *page.Contents[len(page.Contents)-1].Key
-			isTruncated = *page.IsTruncated
-
-			return false
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if !isTruncated {
-			break
-		}
-	}
-
-	return objectList, nil
+	return s.listObjects(ModifiedBefore(beforeTime))
 }
 
 func (s *S3ObjectPrefix) ListObjectsBetweenTimes(afterTime time.Time, beforeTime time.Time) ([]S3Object, error) {
-	s3Session, err := NewS3Session(s.ServiceKey)
-	if err != nil {
-		return nil, err
-	}
+	return s.listObjects(ModifiedAfter(afterTime), ModifiedBefore(beforeTime))
+}
 
+func (s *S3ObjectPrefix) listObjects(filters ...S3ObjectFilter) ([]S3Object, error) {
 	var objectList []S3Object
-	var startAfter string
-	var isTruncated bool
 
-	for {
-		err := s3Session.ListObjectsV2Pages(&s3.ListObjectsV2Input{
-			Bucket:     aws.String(s.Bucket),
-			Prefix:     aws.String(s.Prefix),
-			FetchOwner: aws.Bool(true),
-			StartAfter: aws.String(startAfter),
-		}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
-			if *page.KeyCount == 0 {
-				return true
-			}
-			for i := range page.Contents {
-				if (*page.Contents[i].LastModified).After(afterTime) && (*page.Contents[i].LastModified).Before(beforeTime) {
-					pageContents := page.Contents[i]
-					objectList = append(objectList, S3Object{
-						ServiceKey:   "",
-						Bucket:       s.Bucket,
-						ObjectKey:    *pageContents.Key,
-						ETag:         strings.ReplaceAll(*page.Contents[i].ETag, "\"", ""),
-						Size:         *pageContents.Size,
-						StorageClass: *pageContents.StorageClass,
-						LastModified: *pageContents.LastModified,
-					})
-				}
-			}
-			if len(page.Contents) == 0 {
-				return true
-			}
-
-			startAfter = *page.Contents[len(page.Contents)-1].Key
-			isTruncated = *page.IsTruncated
-
-			return false
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		if !isTruncated {
-			break
-		}
+	iterator := s.Iterator(filters...)
+	for iterator.Next() {
+		objectList = append(objectList, iterator.Object())
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, err
 	}
 
 	return objectList, nil
 }
 
 func (s *S3ObjectPrefix) DeleteObjects() error {
-	s3ObjectList, err := s.ListObjects()
+	report, err := s.DeleteObjectsWithOptions(S3DeleteOptions{})
 	if err != nil {
-		log.Println("List Error")
 		return err
 	}
 
-	for i := range s3ObjectList {
-		s3ObjectList[i].ServiceKey = s.ServiceKey
-		err := s3ObjectList[i].Delete()
-		if err != nil {
-			log.Println("Delete Error")
-			return err
+	if len(report.Errors) > 0 {
+		partialFailure := &S3PartialFailureError{
+			Op:     "DeleteObjects",
+			Bucket: s.Bucket,
+		}
+		for _, deleteError := range report.Errors {
+			partialFailure.Failures = append(partialFailure.Failures, S3ItemError{
+				Key:     deleteError.ObjectKey,
+				Code:    deleteError.Code,
+				Message: deleteError.Message,
+			})
 		}
+		return partialFailure
 	}
 
 	return nil
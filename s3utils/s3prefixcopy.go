@@ -0,0 +1,306 @@
+package s3utils
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"strings"
+	"sync"
+)
+
+// sourceETagMetadataKey is the user metadata key under which copies record the ETag of their source
+const sourceETagMetadataKey = "source-etag"
+
+const (
+	S3CopyStatusCopied  = "copied"
+	S3CopyStatusSkipped = "skipped"
+	S3CopyStatusFailed  = "failed"
+)
+
+type S3CopyOptions struct {
+	Concurrency       int    `json:"concurrency"`
+	SkipUnchanged     bool   `json:"skipUnchanged"`     // Skip objects whose target already has the same size and source ETag
+	ChecksumAlgorithm string `json:"checksumAlgorithm"` // Store a checksum of this algorithm with each copied object
+}
+
+type S3CopyResult struct {
+	SourceKey     string `json:"sourceKey"`
+	TargetKey     string `json:"targetKey"`
+	Size          int64  `json:"size"`
+	Status        string `json:"status"`
+	SourceDeleted bool   `json:"sourceDeleted,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+type S3CopyReport struct {
+	Results []S3CopyResult `json:"results"`
+	Copied  int64          `json:"copied"`
+	Skipped int64          `json:"skipped"`
+	Failed  int64          `json:"failed"`
+}
+
+// CopyTo copies every object under the prefix to the target prefix, replacing the source prefix of each key with
+// the target prefix. Objects are copied server side when both prefixes share credentials, even across regions, and
+// streamed through this process otherwise, while the listing continues. Failures for individual objects are collected
+// in the report; the returned error is only set when listing fails, in which case the report holds what was copied
+// until then.
+func (s *S3ObjectPrefix) CopyTo(target S3ObjectPrefix, options S3CopyOptions) (S3CopyReport, error) {
+	report, _, err := s.copyTo(target, options)
+	return report, err
+}
+
+// MoveTo copies every object under the prefix to the target prefix and deletes each source object that was copied
+// or found unchanged at the target
+func (s *S3ObjectPrefix) MoveTo(target S3ObjectPrefix, options S3CopyOptions) (S3CopyReport, error) {
+	report, sourceBase, err := s.copyTo(target, options)
+	if err != nil {
+		return report, err
+	}
+
+	sourceSession, err := sourceBase.s3Client()
+	if err != nil {
+		return report, err
+	}
+
+	var objectIdentifiers []types.ObjectIdentifier
+	resultIndexes := make(map[string]int)
+	for i, result := range report.Results {
+		if result.Status == S3CopyStatusFailed {
+			continue
+		}
+		objectIdentifiers = append(objectIdentifiers, types.ObjectIdentifier{
+			Key: aws.String(result.SourceKey),
+		})
+		resultIndexes[result.SourceKey] = i
+	}
+
+	deleteReport := deleteObjectIdentifiers(sourceSession, s.Bucket, objectIdentifiers, options.Concurrency)
+	for _, deleted := range deleteReport.Deleted {
+		report.Results[resultIndexes[deleted.ObjectKey]].SourceDeleted = true
+	}
+	for _, deleteError := range deleteReport.Errors {
+		report.Results[resultIndexes[deleteError.ObjectKey]].Error = "error deleting source object: " +
+			deleteError.Code + ": " + deleteError.Message
+	}
+
+	return report, nil
+}
+
+func (s *S3ObjectPrefix) copyTo(target S3ObjectPrefix, options S3CopyOptions) (S3CopyReport, S3Object, error) {
+	sourceBase, err := s.localizedObject()
+	if err != nil {
+		return S3CopyReport{}, S3Object{}, err
+	}
+	targetBase, err := target.localizedObject()
+	if err != nil {
+		return S3CopyReport{}, S3Object{}, err
+	}
+	targetSession, err := targetBase.s3Client()
+	if err != nil {
+		return S3CopyReport{}, S3Object{}, err
+	}
+
+	var report S3CopyReport
+	var mutex sync.Mutex
+	pool := newWorkerPool(options.Concurrency)
+	iterator := s.Iterator()
+	for iterator.Next() {
+		sourceObject := iterator.Object()
+		sourceObject.ServiceKey = sourceBase.ServiceKey
+		sourceObject.Region = sourceBase.Region
+		targetObject := targetBase
+		targetObject.ObjectKey = target.Prefix + strings.TrimPrefix(sourceObject.ObjectKey, s.Prefix)
+
+		// Results keep the listing order, with each slot filled in once its copy finishes
+		mutex.Lock()
+		i := len(report.Results)
+		report.Results = append(report.Results, S3CopyResult{})
+		mutex.Unlock()
+
+		var skip bool
+		pool.submit(func() error {
+			var err error
+			if options.SkipUnchanged {
+				skip, err = isUnchanged(targetSession, sourceObject, targetObject)
+			}
+			if err == nil && !skip {
+				err = sourceObject.copyTo(targetSession, targetObject, options.ChecksumAlgorithm, true)
+			}
+			return err
+		}, func(err error) {
+			result := S3CopyResult{
+				SourceKey: sourceObject.ObjectKey,
+				TargetKey: targetObject.ObjectKey,
+				Size:      sourceObject.Size,
+				Status:    S3CopyStatusCopied,
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case err != nil:
+				result.Status = S3CopyStatusFailed
+				result.Error = err.Error()
+				report.Failed++
+			case skip:
+				result.Status = S3CopyStatusSkipped
+				report.Skipped++
+			default:
+				report.Copied++
+			}
+			report.Results[i] = result
+		})
+	}
+	pool.wait()
+	if err := iterator.Err(); err != nil {
+		return report, sourceBase, err
+	}
+
+	return report, sourceBase, nil
+}
+
+// localizedObject returns an object template for the prefix bucket with the bucket region resolved, so that every
+// object under the prefix can share it
+func (s *S3ObjectPrefix) localizedObject() (S3Object, error) {
+	// An injected client already determines the region
+	if s.Client != nil {
+		return S3Object{
+			Bucket:      s.Bucket,
+			RetryPolicy: s.RetryPolicy,
+			RoleChain:   s.RoleChain,
+			Client:      s.Client,
+		}, nil
+	}
+
+	region, err := getBucketRegion(s.Bucket, s.ServiceKey, s.RetryPolicy, s.RoleChain)
+	if err != nil {
+		return S3Object{}, err
+	}
+
+	return S3Object{
+		ServiceKey:  s.ServiceKey,
+		Region:      region,
+		Bucket:      s.Bucket,
+		RetryPolicy: s.RetryPolicy,
+		RoleChain:   s.RoleChain,
+	}, nil
+}
+
+// CopyWithOptions copies the object to the target, storing a checksum of options.ChecksumAlgorithm with the copy
+// when it is set. Concurrency and SkipUnchanged only apply to prefix copies.
+func (s *S3Object) CopyWithOptions(target S3Object, options S3CopyOptions) (err error) {
+	defer wrapS3Error(&err, "CopyObject", s.Bucket, s.ObjectKey)
+
+	targetSession, err := target.s3Client()
+	if err != nil {
+		return err
+	}
+
+	return s.copyTo(targetSession, target, options.ChecksumAlgorithm, false)
+}
+
+// copyTo copies the object server side when source and target share credentials, using a multipart copy for
+// objects too large for CopyObject, and streams it through this process otherwise. Prefix copies set
+// recordSourceETag so that SkipUnchanged can recognize the copy later.
+func (s *S3Object) copyTo(targetSession S3Client, target S3Object, checksumAlgorithm string, recordSourceETag bool) error {
+	if !s.sharesCredentials(target) {
+		return s.crossRegionMultipartCopy(target, checksumAlgorithm, recordSourceETag)
+	}
+
+	sourceSession, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+	headObjectResult, err := sourceSession.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.ToInt64(headObjectResult.ContentLength) > maxCopyObjectSize {
+		createInput := &s3.CreateMultipartUploadInput{
+			Bucket:                  aws.String(target.Bucket),
+			Key:                     aws.String(target.ObjectKey),
+			CacheControl:            headObjectResult.CacheControl,
+			ContentDisposition:      headObjectResult.ContentDisposition,
+			ContentEncoding:         headObjectResult.ContentEncoding,
+			ContentLanguage:         headObjectResult.ContentLanguage,
+			ContentType:             headObjectResult.ContentType,
+			Metadata:                copyMetadata(headObjectResult, recordSourceETag),
+			WebsiteRedirectLocation: headObjectResult.WebsiteRedirectLocation,
+			StorageClass:            headObjectResult.StorageClass,
+			ServerSideEncryption:    headObjectResult.ServerSideEncryption,
+			SSEKMSKeyId:             headObjectResult.SSEKMSKeyId,
+			BucketKeyEnabled:        headObjectResult.BucketKeyEnabled,
+		}
+		if checksumAlgorithm != "" {
+			createInput.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+		}
+		return s.multipartCopy(target, createInput)
+	}
+
+	// The metadata is replaced to record the source ETag, so the headers, storage class and encryption of the source
+	// have to be set again
+	copyObjectInput := &s3.CopyObjectInput{
+		CopySource:              aws.String(s.copySource()),
+		Bucket:                  aws.String(target.Bucket),
+		Key:                     aws.String(target.ObjectKey),
+		MetadataDirective:       types.MetadataDirectiveReplace,
+		CacheControl:            headObjectResult.CacheControl,
+		ContentDisposition:      headObjectResult.ContentDisposition,
+		ContentEncoding:         headObjectResult.ContentEncoding,
+		ContentLanguage:         headObjectResult.ContentLanguage,
+		ContentType:             headObjectResult.ContentType,
+		Metadata:                copyMetadata(headObjectResult, recordSourceETag),
+		WebsiteRedirectLocation: headObjectResult.WebsiteRedirectLocation,
+		StorageClass:            headObjectResult.StorageClass,
+		ServerSideEncryption:    headObjectResult.ServerSideEncryption,
+		SSEKMSKeyId:             headObjectResult.SSEKMSKeyId,
+		BucketKeyEnabled:        headObjectResult.BucketKeyEnabled,
+	}
+	if checksumAlgorithm != "" {
+		copyObjectInput.ChecksumAlgorithm = types.ChecksumAlgorithm(checksumAlgorithm)
+	}
+	_, err = targetSession.CopyObject(context.Background(), copyObjectInput)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// copyMetadata returns the user metadata of the source for its copy. With recordSourceETag the ETag of the source is
+// added under sourceETagMetadataKey, since multipart and KMS encrypted copies get an ETag of their own and
+// isUnchanged compares the recorded ETag instead.
+func copyMetadata(headObjectResult *s3.HeadObjectOutput, recordSourceETag bool) map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range headObjectResult.Metadata {
+		metadata[key] = value
+	}
+	if recordSourceETag {
+		metadata[sourceETagMetadataKey] = strings.ReplaceAll(aws.ToString(headObjectResult.ETag), "\"", "")
+	}
+	return metadata
+}
+
+func isUnchanged(targetSession S3Client, source S3Object, target S3Object) (bool, error) {
+	output, err := targetSession.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(target.Bucket),
+		Key:    aws.String(target.ObjectKey),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if aws.ToInt64(output.ContentLength) != source.Size || source.ETag == "" {
+		return false, nil
+	}
+	return strings.ReplaceAll(aws.ToString(output.ETag), "\"", "") == source.ETag ||
+		output.Metadata[sourceETagMetadataKey] == source.ETag, nil
+}
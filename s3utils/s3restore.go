@@ -0,0 +1,194 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"strings"
+	"time"
+)
+
+const (
+	RestoreTierExpedited = string(types.TierExpedited)
+	RestoreTierStandard  = string(types.TierStandard)
+	RestoreTierBulk      = string(types.TierBulk)
+)
+
+// errCodeRestoreAlreadyInProgress is not modeled by the SDK but is returned by RestoreObject
+const errCodeRestoreAlreadyInProgress = "RestoreAlreadyInProgress"
+
+type S3RestoreStatus struct {
+	Requested  bool      `json:"requested"`
+	InProgress bool      `json:"inProgress"`
+	ExpiryDate time.Time `json:"expiryDate"`
+}
+
+// Restored reports whether a temporary copy of the archived object is currently available
+func (r S3RestoreStatus) Restored() bool {
+	return r.Requested && !r.InProgress
+}
+
+// ParseRestoreStatus parses the value of the x-amz-restore header, for example:
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func ParseRestoreStatus(header string) (S3RestoreStatus, error) {
+	var status S3RestoreStatus
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return status, nil
+	}
+
+	for header != "" {
+		tokens := strings.SplitN(header, "=", 2)
+		if len(tokens) != 2 {
+			return S3RestoreStatus{}, errors.New("invalid restore header: missing value for '" + tokens[0] + "'")
+		}
+		name := strings.TrimSpace(tokens[0])
+		rest := strings.TrimSpace(tokens[1])
+		if !strings.HasPrefix(rest, "\"") {
+			return S3RestoreStatus{}, errors.New("invalid restore header: value for '" + name + "' must be quoted")
+		}
+		end := strings.Index(rest[1:], "\"")
+		if end < 0 {
+			return S3RestoreStatus{}, errors.New("invalid restore header: unterminated value for '" + name + "'")
+		}
+		value := rest[1 : end+1]
+		header = strings.TrimPrefix(strings.TrimSpace(rest[end+2:]), ",")
+		header = strings.TrimSpace(header)
+
+		switch name {
+		case "ongoing-request":
+			status.Requested = true
+			status.InProgress = value == "true"
+		case "expiry-date":
+			expiryDate, err := time.Parse(time.RFC1123, value)
+			if err != nil {
+				return S3RestoreStatus{}, errors.New("invalid restore header: invalid expiry-date: " + err.Error())
+			}
+			status.ExpiryDate = expiryDate
+		}
+	}
+
+	return status, nil
+}
+
+func (s *S3Object) RestoreObject(tier string, days int64) (err error) {
+	defer wrapS3Error(&err, "RestoreObject", s.Bucket, s.ObjectKey)
+
+	if days < 1 {
+		return errors.New("invalid restore request: days must be at least 1")
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Session.RestoreObject(context.Background(), &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+		RestoreRequest: &types.RestoreRequest{
+			Days: aws.Int32(int32(days)),
+			GlacierJobParameters: &types.GlacierJobParameters{
+				Tier: types.Tier(tier),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3Object) RestoreStatus() (status S3RestoreStatus, err error) {
+	defer wrapS3Error(&err, "HeadObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return S3RestoreStatus{}, err
+	}
+
+	output, err := s3Session.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	})
+	if err != nil {
+		return S3RestoreStatus{}, err
+	}
+
+	return ParseRestoreStatus(aws.ToString(output.Restore))
+}
+
+// WaitForRestore polls the restore status of the object until the restored copy is available or the timeout expires
+func (s *S3Object) WaitForRestore(pollInterval time.Duration, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := s.RestoreStatus()
+		if err != nil {
+			return err
+		}
+		if !status.Requested {
+			return errors.New("no restore has been requested for s3://" + s.Bucket + "/" + s.ObjectKey)
+		}
+		if status.Restored() {
+			return nil
+		}
+
+		if time.Now().Add(pollInterval).After(deadline) {
+			return errors.New("timed out waiting for restore of s3://" + s.Bucket + "/" + s.ObjectKey)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// RestoreObjects requests a restore of every archived object under the prefix and returns the objects for which
+// a restore was requested. Objects that are already restored or have a restore in progress are skipped.
+func (s *S3ObjectPrefix) RestoreObjects(tier string, days int64) ([]S3Object, error) {
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	filter := s.filter()
+	var archivedList []S3Object
+	paginator := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
+		Bucket:                   aws.String(s.Bucket),
+		Prefix:                   aws.String(s.Prefix),
+		OptionalObjectAttributes: []types.OptionalObjectAttributes{types.OptionalObjectAttributesRestoreStatus},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, pageContents := range page.Contents {
+			storageClass := pageContents.StorageClass
+			if storageClass != types.ObjectStorageClassGlacier && storageClass != types.ObjectStorageClassDeepArchive {
+				continue
+			}
+			if pageContents.RestoreStatus != nil {
+				continue
+			}
+			s3Object := newS3ObjectFromListing(*s, pageContents)
+			if filter(s3Object) {
+				archivedList = append(archivedList, s3Object)
+			}
+		}
+	}
+
+	var restoredList []S3Object
+	for i := range archivedList {
+		err := archivedList[i].RestoreObject(tier, days)
+		if err != nil {
+			if awsErrorCode(err) == errCodeRestoreAlreadyInProgress {
+				continue
+			}
+			return restoredList, err
+		}
+		restoredList = append(restoredList, archivedList[i])
+	}
+
+	return restoredList, nil
+}
@@ -0,0 +1,207 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"io"
+)
+
+const (
+	S3SelectFormatCSV     = "CSV"
+	S3SelectFormatJSON    = "JSON"
+	S3SelectFormatParquet = "Parquet"
+)
+
+type S3SelectOptions struct {
+	Expression       string `json:"expression"`       // SQL expression, for example SELECT s.name FROM S3Object s
+	InputFormat      string `json:"inputFormat"`      // S3SelectFormatCSV (default), S3SelectFormatJSON or S3SelectFormatParquet
+	InputCompression string `json:"inputCompression"` // NONE, GZIP or BZIP2 for CSV and JSON input
+	CSVHeaderInfo    string `json:"csvHeaderInfo"`    // USE, IGNORE or NONE for CSV input
+	CSVDelimiter     string `json:"csvDelimiter"`     // Field delimiter of CSV input and output, "," by default
+	JSONType         string `json:"jsonType"`         // DOCUMENT or LINES for JSON input, LINES by default
+	OutputFormat     string `json:"outputFormat"`     // S3SelectFormatCSV or S3SelectFormatJSON, JSON by default for non-CSV input
+
+	Progress func(stats S3SelectStats) `json:"-"` // Called for each progress event while records are read
+}
+
+type S3SelectStats struct {
+	BytesScanned   int64 `json:"bytesScanned"`
+	BytesProcessed int64 `json:"bytesProcessed"`
+	BytesReturned  int64 `json:"bytesReturned"`
+}
+
+// Select runs an S3 Select query against the object and returns a reader of the serialized result records
+func (s *S3Object) Select(options S3SelectOptions) (selectReader *S3SelectReader, err error) {
+	defer wrapS3Error(&err, "SelectObjectContent", s.Bucket, s.ObjectKey)
+
+	if options.Expression == "" {
+		return nil, errors.New("invalid select options: expression must not be empty")
+	}
+
+	inputSerialization, err := selectInputSerialization(options)
+	if err != nil {
+		return nil, err
+	}
+	outputSerialization, err := selectOutputSerialization(options)
+	if err != nil {
+		return nil, err
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := s3Session.SelectObjectContent(context.Background(), &s3.SelectObjectContentInput{
+		Bucket:              aws.String(s.Bucket),
+		Key:                 aws.String(s.ObjectKey),
+		Expression:          aws.String(options.Expression),
+		ExpressionType:      types.ExpressionTypeSql,
+		InputSerialization:  inputSerialization,
+		OutputSerialization: outputSerialization,
+		RequestProgress: &types.RequestProgress{
+			Enabled: aws.Bool(options.Progress != nil),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3SelectReader{
+		eventStream: output.GetStream(),
+		progress:    options.Progress,
+	}, nil
+}
+
+func selectInputSerialization(options S3SelectOptions) (*types.InputSerialization, error) {
+	inputSerialization := &types.InputSerialization{}
+	if options.InputCompression != "" {
+		inputSerialization.CompressionType = types.CompressionType(options.InputCompression)
+	}
+
+	switch options.InputFormat {
+	case S3SelectFormatCSV, "":
+		inputSerialization.CSV = &types.CSVInput{}
+		if options.CSVHeaderInfo != "" {
+			inputSerialization.CSV.FileHeaderInfo = types.FileHeaderInfo(options.CSVHeaderInfo)
+		}
+		if options.CSVDelimiter != "" {
+			inputSerialization.CSV.FieldDelimiter = aws.String(options.CSVDelimiter)
+		}
+	case S3SelectFormatJSON:
+		jsonType := types.JSONType(options.JSONType)
+		if jsonType == "" {
+			jsonType = types.JSONTypeLines
+		}
+		inputSerialization.JSON = &types.JSONInput{
+			Type: jsonType,
+		}
+	case S3SelectFormatParquet:
+		inputSerialization.Parquet = &types.ParquetInput{}
+	default:
+		return nil, errors.New("invalid select options: unsupported input format '" + options.InputFormat + "'")
+	}
+
+	return inputSerialization, nil
+}
+
+func selectOutputSerialization(options S3SelectOptions) (*types.OutputSerialization, error) {
+	outputFormat := options.OutputFormat
+	if outputFormat == "" {
+		outputFormat = S3SelectFormatJSON
+		if options.InputFormat == S3SelectFormatCSV || options.InputFormat == "" {
+			outputFormat = S3SelectFormatCSV
+		}
+	}
+
+	switch outputFormat {
+	case S3SelectFormatCSV:
+		csvOutput := &types.CSVOutput{}
+		if options.CSVDelimiter != "" {
+			csvOutput.FieldDelimiter = aws.String(options.CSVDelimiter)
+		}
+		return &types.OutputSerialization{CSV: csvOutput}, nil
+	case S3SelectFormatJSON:
+		return &types.OutputSerialization{JSON: &types.JSONOutput{}}, nil
+	}
+
+	return nil, errors.New("invalid select options: unsupported output format '" + outputFormat + "'")
+}
+
+// S3SelectReader streams the records returned by a Select query. Stats is available once Read has returned
+// io.EOF. A query that stops without an end event returns an error rather than io.EOF, since the records may be
+// incomplete.
+type S3SelectReader struct {
+	eventStream *s3.SelectObjectContentEventStream
+	progress    func(stats S3SelectStats)
+	pending     []byte
+	stats       S3SelectStats
+	ended       bool
+	err         error
+}
+
+func (r *S3SelectReader) Read(b []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		event, open := <-r.eventStream.Events()
+		if !open {
+			r.err = r.eventStream.Err()
+			if r.err == nil && !r.ended {
+				r.err = errors.New("select query ended without an end event: results may be incomplete")
+			}
+			if r.err == nil {
+				r.err = io.EOF
+			}
+			continue
+		}
+
+		switch e := event.(type) {
+		case *types.SelectObjectContentEventStreamMemberRecords:
+			r.pending = e.Value.Payload
+		case *types.SelectObjectContentEventStreamMemberStats:
+			r.stats = newS3SelectStats(e.Value.Details)
+		case *types.SelectObjectContentEventStreamMemberProgress:
+			if r.progress != nil {
+				r.progress(newS3SelectStats(e.Value.Details))
+			}
+		case *types.SelectObjectContentEventStreamMemberEnd:
+			r.ended = true
+		}
+	}
+
+	n := copy(b, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *S3SelectReader) Stats() S3SelectStats {
+	return r.stats
+}
+
+func (r *S3SelectReader) Close() error {
+	return r.eventStream.Close()
+}
+
+func newS3SelectStats(details interface{}) S3SelectStats {
+	switch d := details.(type) {
+	case *types.Stats:
+		return S3SelectStats{
+			BytesScanned:   aws.ToInt64(d.BytesScanned),
+			BytesProcessed: aws.ToInt64(d.BytesProcessed),
+			BytesReturned:  aws.ToInt64(d.BytesReturned),
+		}
+	case *types.Progress:
+		return S3SelectStats{
+			BytesScanned:   aws.ToInt64(d.BytesScanned),
+			BytesProcessed: aws.ToInt64(d.BytesProcessed),
+			BytesReturned:  aws.ToInt64(d.BytesReturned),
+		}
+	}
+	return S3SelectStats{}
+}
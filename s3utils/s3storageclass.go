@@ -0,0 +1,201 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"math"
+	"net/url"
+	"time"
+)
+
+// maxCopyObjectSize is the largest object that can be copied with a single CopyObject request (5 GiB)
+const maxCopyObjectSize = int64(5 * 1024 * 1024 * 1024)
+
+// storageClassMonthlyPricePerGB holds the us-east-1 list price per GB-month of each storage class
+var storageClassMonthlyPricePerGB = map[string]float64{
+	string(types.StorageClassStandard):           0.023,
+	string(types.StorageClassReducedRedundancy):  0.024,
+	string(types.StorageClassIntelligentTiering): 0.023,
+	string(types.StorageClassStandardIa):         0.0125,
+	string(types.StorageClassOnezoneIa):          0.01,
+	string(types.StorageClassGlacierIr):          0.004,
+	string(types.StorageClassGlacier):            0.0036,
+	string(types.StorageClassDeepArchive):        0.00099,
+}
+
+// StorageClassMonthlyPricePerGB returns the per GB-month storage price used to estimate savings, and whether the
+// storage class is known
+func StorageClassMonthlyPricePerGB(storageClass string) (float64, bool) {
+	price, defined := storageClassMonthlyPricePerGB[storageClass]
+	return price, defined
+}
+
+type S3StorageClassOptions struct {
+	StorageClass string        `json:"storageClass"`
+	OlderThan    time.Duration `json:"olderThan"` // Only objects last modified more than OlderThan ago; zero for all
+	MinSize      int64         `json:"minSize"`
+	MaxSize      int64         `json:"maxSize"` // Zero for no upper bound
+	DryRun       bool          `json:"dryRun"`
+}
+
+type S3StorageClassError struct {
+	ObjectKey string `json:"objectKey"`
+	Code      string `json:"code,omitempty"`
+	Message   string `json:"message"`
+}
+
+type S3StorageClassReport struct {
+	StorageClass            string                `json:"storageClass"`
+	DryRun                  bool                  `json:"dryRun"`
+	Objects                 []S3Object            `json:"objects"`
+	Errors                  []S3StorageClassError `json:"errors"`
+	TotalSize               int64                 `json:"totalSize"`
+	EstimatedMonthlySavings float64               `json:"estimatedMonthlySavings"`
+}
+
+func (s *S3Object) ChangeStorageClass(storageClass string) (err error) {
+	defer wrapS3Error(&err, "ChangeStorageClass", s.Bucket, s.ObjectKey)
+
+	if storageClass == "" {
+		return errors.New("invalid storage class: storage class cannot be empty")
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+
+	headObjectResult, err := s3Session.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	if aws.ToInt64(headObjectResult.ContentLength) <= maxCopyObjectSize {
+		// MetadataDirective COPY preserves the existing user metadata and system headers, but not the encryption,
+		// which falls back to the bucket default unless it is set again
+		_, err = s3Session.CopyObject(context.Background(), &s3.CopyObjectInput{
+			CopySource:           aws.String(s.copySource()),
+			Bucket:               aws.String(s.Bucket),
+			Key:                  aws.String(s.ObjectKey),
+			MetadataDirective:    types.MetadataDirectiveCopy,
+			SSEKMSKeyId:          headObjectResult.SSEKMSKeyId,
+			ServerSideEncryption: headObjectResult.ServerSideEncryption,
+			BucketKeyEnabled:     headObjectResult.BucketKeyEnabled,
+			StorageClass:         types.StorageClass(storageClass),
+		})
+		if err != nil {
+			return err
+		}
+
+		s.StorageClass = storageClass
+		return nil
+	}
+
+	// Multipart uploads do not carry over metadata or tags from the source, so set them explicitly
+	taggingResult, err := s3Session.GetObjectTagging(context.Background(), &s3.GetObjectTaggingInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	})
+	if err != nil {
+		return err
+	}
+	tagging := url.Values{}
+	for _, tag := range taggingResult.TagSet {
+		tagging.Add(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+
+	createInput := &s3.CreateMultipartUploadInput{
+		Bucket:                  aws.String(s.Bucket),
+		Key:                     aws.String(s.ObjectKey),
+		CacheControl:            headObjectResult.CacheControl,
+		ContentDisposition:      headObjectResult.ContentDisposition,
+		ContentEncoding:         headObjectResult.ContentEncoding,
+		ContentLanguage:         headObjectResult.ContentLanguage,
+		ContentType:             headObjectResult.ContentType,
+		Metadata:                headObjectResult.Metadata,
+		SSEKMSKeyId:             headObjectResult.SSEKMSKeyId,
+		ServerSideEncryption:    headObjectResult.ServerSideEncryption,
+		BucketKeyEnabled:        headObjectResult.BucketKeyEnabled,
+		StorageClass:            types.StorageClass(storageClass),
+		WebsiteRedirectLocation: headObjectResult.WebsiteRedirectLocation,
+	}
+	if len(tagging) > 0 {
+		createInput.Tagging = aws.String(tagging.Encode())
+	}
+
+	err = s.multipartCopy(*s, createInput)
+	if err != nil {
+		return err
+	}
+
+	s.StorageClass = storageClass
+	return nil
+}
+
+// ChangeStorageClass moves the objects under the prefix that match the options to a new storage class. Archived
+// objects (GLACIER, DEEP_ARCHIVE) and objects already in the target class are skipped. Failures for individual
+// objects are collected in the report; the returned error is only set when listing fails.
+func (s *S3ObjectPrefix) ChangeStorageClass(options S3StorageClassOptions) (S3StorageClassReport, error) {
+	if options.StorageClass == "" {
+		return S3StorageClassReport{}, errors.New("invalid storage class: storage class cannot be empty")
+	}
+	if _, defined := storageClassMonthlyPricePerGB[options.StorageClass]; !defined {
+		return S3StorageClassReport{}, errors.New("invalid storage class: unknown storage class '" + options.StorageClass + "'")
+	}
+
+	report := S3StorageClassReport{
+		StorageClass: options.StorageClass,
+		DryRun:       options.DryRun,
+	}
+	cutoff := time.Now().Add(-options.OlderThan)
+	iterator := s.Iterator(func(s3Object S3Object) bool {
+		if s3Object.StorageClass == options.StorageClass ||
+			s3Object.StorageClass == string(types.ObjectStorageClassGlacier) ||
+			s3Object.StorageClass == string(types.ObjectStorageClassDeepArchive) {
+			return false
+		}
+		if options.OlderThan > 0 && !s3Object.LastModified.Before(cutoff) {
+			return false
+		}
+		return s3Object.Size >= options.MinSize && (options.MaxSize == 0 || s3Object.Size <= options.MaxSize)
+	})
+	for iterator.Next() {
+		s3Object := iterator.Object()
+		currentStorageClass := s3Object.StorageClass
+		if !options.DryRun {
+			err := s3Object.ChangeStorageClass(options.StorageClass)
+			if err != nil {
+				report.Errors = append(report.Errors, S3StorageClassError{
+					ObjectKey: s3Object.ObjectKey,
+					Code:      awsErrorCode(err),
+					Message:   err.Error(),
+				})
+				continue
+			}
+		}
+
+		report.Objects = append(report.Objects, s3Object)
+		report.TotalSize += s3Object.Size
+		report.EstimatedMonthlySavings += estimateMonthlySavings(s3Object.Size, currentStorageClass, options.StorageClass)
+	}
+	if err := iterator.Err(); err != nil {
+		return report, err
+	}
+	report.EstimatedMonthlySavings = math.Round(report.EstimatedMonthlySavings*100) / 100
+
+	return report, nil
+}
+
+func estimateMonthlySavings(size int64, fromStorageClass string, toStorageClass string) float64 {
+	if fromStorageClass == "" {
+		fromStorageClass = string(types.StorageClassStandard)
+	}
+	sizeInGB := float64(size) / math.Pow(1024, 3)
+	return sizeInGB * (storageClassMonthlyPricePerGB[fromStorageClass] - storageClassMonthlyPricePerGB[toStorageClass])
+}
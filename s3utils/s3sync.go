@@ -0,0 +1,448 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	S3SyncActionUpload   = "upload"
+	S3SyncActionDownload = "download"
+	S3SyncActionCopy     = "copy"
+	S3SyncActionDelete   = "delete"
+)
+
+type S3SyncOptions struct {
+	Delete          bool     `json:"delete"`          // Remove files from the destination that do not exist in the source
+	Exclude         []string `json:"exclude"`         // Glob patterns matched against paths relative to the source
+	CompareChecksum bool     `json:"compareChecksum"` // Compare MD5/ETag instead of modification time when sizes match
+	DryRun          bool     `json:"dryRun"`
+	Concurrency     int      `json:"concurrency"`
+}
+
+type S3SyncAction struct {
+	Action string `json:"action"`
+	Path   string `json:"path"` // Path relative to the source and destination roots
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+}
+
+type S3SyncReport struct {
+	DryRun  bool           `json:"dryRun"`
+	Actions []S3SyncAction `json:"actions"`
+	Failed  int64          `json:"failed"`
+}
+
+// syncEntry describes a file or object by its path relative to the sync root
+type syncEntry struct {
+	path      string
+	size      int64
+	modTime   time.Time
+	etag      string // Only set for S3 objects
+	localPath string // Only set for local files
+}
+
+// SyncLocalToS3 uploads files from localDir that are missing or changed under the target prefix
+func SyncLocalToS3(localDir string, target S3ObjectPrefix, options S3SyncOptions) (S3SyncReport, error) {
+	sourceEntries, err := listLocalEntries(localDir, false)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetBase, err := target.localizedObject()
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetEntries, err := listS3Entries(target)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	actions, err := planSync(sourceEntries, targetEntries, S3SyncActionUpload, options)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	return executeSync(actions, options, func(action S3SyncAction) error {
+		targetObject := targetBase
+		targetObject.ObjectKey = target.directoryPrefix() + action.Path
+		if action.Action == S3SyncActionDelete {
+			return targetObject.Delete()
+		}
+
+		file, err := os.Open(sourceEntries[action.Path].localPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return targetObject.UploadReader(file)
+	})
+}
+
+// SyncS3ToLocal downloads objects under the source prefix that are missing or changed in localDir
+func SyncS3ToLocal(source S3ObjectPrefix, localDir string, options S3SyncOptions) (S3SyncReport, error) {
+	sourceBase, err := source.localizedObject()
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	sourceEntries, err := listS3Entries(source)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetEntries, err := listLocalEntries(localDir, true)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	actions, err := planSync(sourceEntries, targetEntries, S3SyncActionDownload, options)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	return executeSync(actions, options, func(action S3SyncAction) error {
+		localPath, err := syncLocalPath(localDir, action.Path)
+		if err != nil {
+			return err
+		}
+		if action.Action == S3SyncActionDelete {
+			return os.Remove(localPath)
+		}
+
+		sourceObject := sourceBase
+		sourceObject.ObjectKey = source.directoryPrefix() + action.Path
+		err = sourceObject.downloadToFile(localPath)
+		if err != nil {
+			return err
+		}
+
+		// Match the local modification time to the object so that unchanged files are skipped next time
+		modTime := sourceEntries[action.Path].modTime
+		return os.Chtimes(localPath, modTime, modTime)
+	})
+}
+
+// SyncS3ToS3 copies objects under the source prefix that are missing or changed under the target prefix
+func SyncS3ToS3(source S3ObjectPrefix, target S3ObjectPrefix, options S3SyncOptions) (S3SyncReport, error) {
+	sourceBase, err := source.localizedObject()
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetBase, err := target.localizedObject()
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetSession, err := targetBase.s3Client()
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	sourceEntries, err := listS3Entries(source)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+	targetEntries, err := listS3Entries(target)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	actions, err := planSync(sourceEntries, targetEntries, S3SyncActionCopy, options)
+	if err != nil {
+		return S3SyncReport{}, err
+	}
+
+	return executeSync(actions, options, func(action S3SyncAction) error {
+		targetObject := targetBase
+		targetObject.ObjectKey = target.directoryPrefix() + action.Path
+		if action.Action == S3SyncActionDelete {
+			return targetObject.Delete()
+		}
+
+		sourceObject := sourceBase
+		sourceObject.ObjectKey = source.directoryPrefix() + action.Path
+		sourceObject.Size = sourceEntries[action.Path].size
+		return sourceObject.copyTo(targetSession, targetObject, "", false)
+	})
+}
+
+// syncLocalPath returns the path of an entry under localDir. Object keys may contain ".." segments, so paths that
+// resolve outside of localDir are rejected.
+func syncLocalPath(localDir string, path string) (string, error) {
+	localPath := filepath.Join(localDir, filepath.FromSlash(path))
+	relativePath, err := filepath.Rel(localDir, localPath)
+	if err != nil || relativePath == "." || relativePath == ".." ||
+		strings.HasPrefix(relativePath, ".."+string(os.PathSeparator)) {
+		return "", errors.New("invalid path: '" + path + "' resolves outside of '" + localDir + "'")
+	}
+	return localPath, nil
+}
+
+func planSync(sourceEntries map[string]syncEntry, targetEntries map[string]syncEntry, transferAction string,
+	options S3SyncOptions) ([]S3SyncAction, error) {
+	var excludeExpressions []*regexp.Regexp
+	for _, pattern := range options.Exclude {
+		expression, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		excludeExpressions = append(excludeExpressions, expression)
+	}
+	excluded := func(path string) bool {
+		for _, expression := range excludeExpressions {
+			if expression.MatchString(path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var actions []S3SyncAction
+	for path, sourceEntry := range sourceEntries {
+		if excluded(path) {
+			continue
+		}
+
+		targetEntry, exists := targetEntries[path]
+		reason, err := compareSyncEntries(sourceEntry, targetEntry, exists, options.CompareChecksum)
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			continue
+		}
+
+		actions = append(actions, S3SyncAction{
+			Action: transferAction,
+			Path:   path,
+			Size:   sourceEntry.size,
+			Reason: reason,
+		})
+	}
+
+	if options.Delete {
+		for path, targetEntry := range targetEntries {
+			if _, exists := sourceEntries[path]; exists || excluded(path) {
+				continue
+			}
+			actions = append(actions, S3SyncAction{
+				Action: S3SyncActionDelete,
+				Path:   path,
+				Size:   targetEntry.size,
+				Reason: "not in source",
+			})
+		}
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Path < actions[j].Path
+	})
+
+	return actions, nil
+}
+
+// compareSyncEntries returns the reason the source needs to be transferred, or an empty string if the target is
+// up to date
+func compareSyncEntries(source syncEntry, target syncEntry, targetExists bool, compareChecksum bool) (string, error) {
+	if !targetExists {
+		return "missing from destination", nil
+	}
+	if source.size != target.size {
+		return "size differs", nil
+	}
+
+	if compareChecksum {
+		equal, known, err := compareSyncChecksums(source, target)
+		if err != nil {
+			return "", err
+		}
+		// When the checksums cannot be compared, for example for a multipart ETag with an unknown part size, fall
+		// back to modification times
+		if known {
+			if !equal {
+				return "checksum differs", nil
+			}
+			return "", nil
+		}
+	}
+
+	if source.modTime.After(target.modTime) {
+		return "source is newer", nil
+	}
+
+	return "", nil
+}
+
+// compareSyncChecksums compares the content of two entries of the same size using MD5 and S3 ETags. known is
+// false when the entries cannot be compared.
+func compareSyncChecksums(source syncEntry, target syncEntry) (equal bool, known bool, err error) {
+	if source.localPath == "" && target.localPath == "" {
+		if source.etag == target.etag {
+			return true, true, nil
+		}
+		// Different multipart ETags may describe the same content uploaded with different part sizes
+		if isMultipartETag(source.etag) || isMultipartETag(target.etag) {
+			return false, false, nil
+		}
+		return false, true, nil
+	}
+
+	localEntry, s3Entry := source, target
+	if source.localPath == "" {
+		localEntry, s3Entry = target, source
+	}
+
+	if isMultipartETag(s3Entry.etag) {
+		if _, err := inferETagPartSizes(s3Entry.etag, s3Entry.size); err != nil {
+			return false, false, nil
+		}
+	}
+
+	file, err := os.Open(localEntry.localPath)
+	if err != nil {
+		return false, false, err
+	}
+	defer file.Close()
+
+	equal, err = etagMatches(file, s3Entry.etag, s3Entry.size)
+	if err != nil {
+		return false, false, err
+	}
+	return equal, true, nil
+}
+
+func executeSync(actions []S3SyncAction, options S3SyncOptions, execute func(action S3SyncAction) error) (S3SyncReport, error) {
+	report := S3SyncReport{
+		DryRun:  options.DryRun,
+		Actions: actions,
+	}
+	if options.DryRun {
+		return report, nil
+	}
+
+	forEachConcurrent(options.Concurrency, len(report.Actions), func(i int) error {
+		return execute(report.Actions[i])
+	}, func(i int, err error) {
+		if err != nil {
+			report.Actions[i].Error = err.Error()
+			atomic.AddInt64(&report.Failed, 1)
+		}
+	})
+
+	return report, nil
+}
+
+// listLocalEntries lists the files under localDir. A missing directory is only listed as empty with allowMissing,
+// which is set for a destination, since a mistyped or unmounted source would otherwise plan a delete of everything
+// at the target.
+func listLocalEntries(localDir string, allowMissing bool) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	_, err := os.Stat(localDir)
+	if os.IsNotExist(err) && allowMissing {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(localDir, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(relativePath)
+		entries[path] = syncEntry{
+			path:      path,
+			size:      info.Size(),
+			modTime:   info.ModTime(),
+			localPath: localPath,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func listS3Entries(prefix S3ObjectPrefix) (map[string]syncEntry, error) {
+	directoryPrefix := prefix.directoryPrefix()
+	prefix.Prefix = directoryPrefix
+
+	entries := make(map[string]syncEntry)
+	iterator := prefix.Iterator()
+	for iterator.Next() {
+		s3Object := iterator.Object()
+		path := strings.TrimPrefix(s3Object.ObjectKey, directoryPrefix)
+		// Zero byte keys ending in "/" are console-created folders and have no local equivalent
+		if path == "" || strings.HasSuffix(path, "/") {
+			continue
+		}
+		entries[path] = syncEntry{
+			path:    path,
+			size:    s3Object.Size,
+			modTime: s3Object.LastModified,
+			etag:    s3Object.ETag,
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// downloadToFile streams the object into a temporary file next to localPath and renames it into place once the
+// download is complete
+func (s *S3Object) downloadToFile(localPath string) error {
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(localPath), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	s3Downloader := manager.NewDownloader(s3Session)
+	_, err = s3Downloader.Download(context.Background(), file,
+		&s3.GetObjectInput{
+			Bucket: aws.String(s.Bucket),
+			Key:    aws.String(s.ObjectKey),
+		})
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), localPath)
+}
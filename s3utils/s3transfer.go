@@ -0,0 +1,329 @@
+package s3utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+type S3UploadOptions struct {
+	ChecksumAlgorithm string       `json:"checksumAlgorithm"` // One of the ChecksumAlgorithm constants; S3 rejects the upload on mismatch
+	Compression       string       `json:"compression"`       // CompressionGzip or CompressionZstd, applied before encryption
+	ContentType       string       `json:"contentType"`
+	KeyWrapper        S3KeyWrapper `json:"-"` // Encrypt the data before upload with a data key wrapped by KeyWrapper
+}
+
+type S3DownloadOptions struct {
+	ValidateChecksum bool         `json:"validateChecksum"` // Fail with an S3ChecksumError if the data does not match the stored checksum
+	Decompress       bool         `json:"decompress"`       // Decompress based on the upload metadata, Content-Encoding or key extension
+	KeyWrapper       S3KeyWrapper `json:"-"`                // Decrypt an object uploaded with client-side encryption
+}
+
+func (s *S3Object) UploadBytesWithOptions(uploadBytes []byte, options S3UploadOptions) error {
+	return s.UploadReaderWithOptions(bytes.NewReader(uploadBytes), options)
+}
+
+func (s *S3Object) UploadReaderWithOptions(reader io.Reader, options S3UploadOptions) (err error) {
+	defer wrapS3Error(&err, "PutObject", s.Bucket, s.ObjectKey)
+
+	metadata := make(map[string]string)
+	var contentEncoding *string
+	if options.Compression != "" {
+		compressedReader, err := newCompressingReader(reader, options.Compression)
+		if err != nil {
+			return err
+		}
+		defer compressedReader.Close()
+
+		reader = compressedReader
+		metadata[metadataCompression] = options.Compression
+		contentEncoding = aws.String(options.Compression)
+	}
+
+	if options.KeyWrapper != nil {
+		encryptedReader, encryptionMetadata, err := newEncryptingReader(reader, options.KeyWrapper)
+		if err != nil {
+			return err
+		}
+		reader = encryptedReader
+		for key, value := range encryptionMetadata {
+			metadata[key] = value
+		}
+		// The stored bytes are ciphertext, so the compression is only recorded in the metadata
+		contentEncoding = nil
+	}
+
+	uploadInput := &s3.PutObjectInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(s.ObjectKey),
+		Body:            reader,
+		ContentEncoding: contentEncoding,
+		Metadata:        metadata,
+	}
+	if options.ContentType != "" {
+		uploadInput.ContentType = aws.String(options.ContentType)
+	}
+
+	if options.ChecksumAlgorithm != "" {
+		return s.uploadWithChecksum(uploadInput, options.ChecksumAlgorithm)
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+
+	s3Uploader := manager.NewUploader(s3Session)
+	_, err = s3Uploader.Upload(context.Background(), uploadInput)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *S3Object) DownloadBytesWithOptions(options S3DownloadOptions) (downloadBytes []byte, err error) {
+	defer wrapS3Error(&err, "GetObject", s.Bucket, s.ObjectKey)
+
+	reader, err := s.DownloadReaderWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// DownloadReaderWithOptions streams the object. When checksum validation or decryption is requested an integrity
+// error is returned by the final Read, so the data should not be trusted until the reader has returned io.EOF.
+func (s *S3Object) DownloadReaderWithOptions(options S3DownloadOptions) (reader io.ReadCloser, err error) {
+	defer wrapS3Error(&err, "GetObject", s.Bucket, s.ObjectKey)
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.ObjectKey),
+	}
+	if options.ValidateChecksum {
+		input.ChecksumMode = types.ChecksumModeEnabled
+	}
+	// Ask for the stored bytes so that the HTTP client does not decompress gzip encoded objects on its own
+	output, err := s3Session.GetObject(context.Background(), input, withoutChecksumValidation,
+		s3.WithAPIOptions(smithyhttp.SetHeaderValue("Accept-Encoding", "identity")))
+	if err != nil {
+		return nil, err
+	}
+
+	body := output.Body
+	if options.ValidateChecksum {
+		body, err = s.validateChecksum(body, checksumMap(map[string]*string{
+			ChecksumAlgorithmCRC32:     output.ChecksumCRC32,
+			ChecksumAlgorithmCRC32C:    output.ChecksumCRC32C,
+			ChecksumAlgorithmSHA1:      output.ChecksumSHA1,
+			ChecksumAlgorithmSHA256:    output.ChecksumSHA256,
+			ChecksumAlgorithmCRC64NVME: output.ChecksumCRC64NVME,
+		}))
+		if err != nil {
+			output.Body.Close()
+			return nil, err
+		}
+	}
+
+	if options.KeyWrapper != nil {
+		body, err = newDecryptingReader(body, output.Metadata, options.KeyWrapper)
+		if err != nil {
+			output.Body.Close()
+			return nil, err
+		}
+	}
+
+	if options.Decompress {
+		if compression := objectCompression(s.ObjectKey, output.Metadata, output.ContentEncoding); compression != "" {
+			body, err = newDecompressingReader(body, compression)
+			if err != nil {
+				output.Body.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return body, nil
+}
+
+// validateChecksum wraps body in a reader that checks it against the preferred stored checksum. Composite
+// checksums of multipart objects cover the parts rather than the whole object and cannot be checked.
+func (s *S3Object) validateChecksum(body io.ReadCloser, checksums map[string]string) (io.ReadCloser, error) {
+	for _, algorithm := range checksumAlgorithms {
+		expected, defined := checksums[algorithm]
+		if !defined || isMultipartETag(expected) {
+			continue
+		}
+
+		checksumHash, err := newChecksumHash(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return &checksumValidatingReader{
+			body:      body,
+			hash:      checksumHash,
+			algorithm: algorithm,
+			expected:  expected,
+			s3Object:  s,
+		}, nil
+	}
+
+	return body, nil
+}
+
+// uploadWithChecksum uploads the input body with a checksum for every request so that S3 can validate the data it
+// receives. Data that fits in a single part is sent with PutObject.
+func (s *S3Object) uploadWithChecksum(uploadInput *s3.PutObjectInput, algorithm string) error {
+	fullObjectHash, err := newChecksumHash(algorithm)
+	if err != nil {
+		return err
+	}
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return err
+	}
+
+	partSize, err := checksumPartSize(uploadInput.Body)
+	if err != nil {
+		return err
+	}
+	partBuffer := make([]byte, partSize)
+	reader := uploadInput.Body
+	n, err := io.ReadFull(reader, partBuffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if int64(n) < partSize {
+		_, err = s3Session.PutObject(context.Background(), &s3.PutObjectInput{
+			Body:              bytes.NewReader(partBuffer[:n]),
+			Bucket:            uploadInput.Bucket,
+			Key:               uploadInput.Key,
+			ChecksumAlgorithm: types.ChecksumAlgorithm(algorithm),
+			ContentEncoding:   uploadInput.ContentEncoding,
+			ContentType:       uploadInput.ContentType,
+			Metadata:          uploadInput.Metadata,
+		})
+		return err
+	}
+
+	uploader, err := s3Session.CreateMultipartUpload(context.Background(), &s3.CreateMultipartUploadInput{
+		Bucket:            uploadInput.Bucket,
+		Key:               uploadInput.Key,
+		ChecksumAlgorithm: types.ChecksumAlgorithm(algorithm),
+		ChecksumType:      checksumType(algorithm),
+		ContentEncoding:   uploadInput.ContentEncoding,
+		ContentType:       uploadInput.ContentType,
+		Metadata:          uploadInput.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+
+	completedParts, err := uploadChecksumParts(s3Session, uploader, reader, partBuffer, n, algorithm, fullObjectHash)
+	if err != nil {
+		abortMultipartUpload(s3Session, uploader)
+		return err
+	}
+
+	completeInput := &s3.CompleteMultipartUploadInput{
+		Bucket: uploader.Bucket,
+		Key:    uploader.Key,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+		UploadId: uploader.UploadId,
+	}
+	setCompleteChecksum(completeInput, algorithm, fullObjectHash)
+	_, err = s3Session.CompleteMultipartUpload(context.Background(), completeInput)
+	if err != nil {
+		abortMultipartUpload(s3Session, uploader)
+		return err
+	}
+
+	return nil
+}
+
+// checksumPartSize returns the part size for a checksummed upload of body. As with manager.Uploader, the default part
+// size grows when the remaining length of a seekable body would otherwise need more than manager.MaxUploadParts parts.
+func checksumPartSize(body io.Reader) (int64, error) {
+	partSize := manager.DefaultUploadPartSize
+	seeker, seekable := body.(io.Seeker)
+	if !seekable {
+		return partSize, nil
+	}
+
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = seeker.Seek(current, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	if size := end - current; size/partSize >= int64(manager.MaxUploadParts) {
+		partSize = size/int64(manager.MaxUploadParts) + 1
+	}
+	return partSize, nil
+}
+
+func uploadChecksumParts(s3Session S3Client, uploader *s3.CreateMultipartUploadOutput, reader io.Reader,
+	partBuffer []byte, n int, algorithm string, fullObjectHash hash.Hash) ([]types.CompletedPart, error) {
+	var completedParts []types.CompletedPart
+	for partNumber := int32(1); n > 0; partNumber++ {
+		if partNumber > manager.MaxUploadParts {
+			return nil, errors.New("invalid upload: data exceeds " + strconv.Itoa(int(manager.MaxUploadParts)) + " parts of " +
+				strconv.Itoa(len(partBuffer)) + " bytes")
+		}
+		part := partBuffer[:n]
+		fullObjectHash.Write(part)
+
+		partResult, err := s3Session.UploadPart(context.Background(), &s3.UploadPartInput{
+			Body:              bytes.NewReader(part),
+			Bucket:            uploader.Bucket,
+			ChecksumAlgorithm: types.ChecksumAlgorithm(algorithm),
+			ContentLength:     aws.Int64(int64(n)),
+			Key:               uploader.Key,
+			PartNumber:        aws.Int32(partNumber),
+			UploadId:          uploader.UploadId,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		completedPart := types.CompletedPart{
+			ETag:       partResult.ETag,
+			PartNumber: aws.Int32(partNumber),
+		}
+		setCompletedPartChecksum(&completedPart, algorithm, uploadPartChecksum(partResult, algorithm))
+		completedParts = append(completedParts, completedPart)
+
+		n, err = io.ReadFull(reader, partBuffer)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+	}
+
+	return completedParts, nil
+}
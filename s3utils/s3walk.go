@@ -0,0 +1,106 @@
+package s3utils
+
+import (
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"strings"
+)
+
+const prefixDelimiter = "/"
+
+// SkipPrefix can be returned from an S3WalkFunc to skip the children of the prefix being visited
+var SkipPrefix = errors.New("skip this prefix")
+
+// S3WalkFunc is called by Walk once for every prefix in the tree with the direct child prefixes and objects
+type S3WalkFunc func(prefix S3ObjectPrefix, childPrefixes []S3ObjectPrefix, objects []S3Object) error
+
+func (s *S3ObjectPrefix) ListCommonPrefixes() ([]S3ObjectPrefix, error) {
+	childPrefixes, _, err := s.readDir(false)
+	if err != nil {
+		return nil, err
+	}
+	return childPrefixes, nil
+}
+
+// ReadDir lists the direct children of the prefix, treating "/" as a directory separator. A prefix that does not
+// end in "/" is treated as a directory of the same name.
+func (s *S3ObjectPrefix) ReadDir() ([]S3ObjectPrefix, []S3Object, error) {
+	return s.readDir(true)
+}
+
+// Walk visits the prefix and every prefix below it depth first, calling fn for each one
+func (s *S3ObjectPrefix) Walk(fn S3WalkFunc) error {
+	childPrefixes, objects, err := s.ReadDir()
+	if err != nil {
+		return err
+	}
+
+	err = fn(*s, childPrefixes, objects)
+	if err == SkipPrefix {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range childPrefixes {
+		err := childPrefixes[i].Walk(fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *S3ObjectPrefix) directoryPrefix() string {
+	if s.Prefix == "" || strings.HasSuffix(s.Prefix, prefixDelimiter) {
+		return s.Prefix
+	}
+	return s.Prefix + prefixDelimiter
+}
+
+func (s *S3ObjectPrefix) readDir(includeObjects bool) (childPrefixes []S3ObjectPrefix, objects []S3Object, err error) {
+	defer wrapS3Error(&err, "ListObjectsV2", s.Bucket, s.directoryPrefix())
+
+	s3Session, err := s.s3Client()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := s.filter()
+	paginator := s3.NewListObjectsV2Paginator(s3Session, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.Bucket),
+		Prefix:    aws.String(s.directoryPrefix()),
+		Delimiter: aws.String(prefixDelimiter),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			childPrefixes = append(childPrefixes, S3ObjectPrefix{
+				ServiceKey:  s.ServiceKey,
+				Bucket:      s.Bucket,
+				Prefix:      aws.ToString(commonPrefix.Prefix),
+				Filters:     s.Filters,
+				RetryPolicy: s.RetryPolicy,
+				RoleChain:   s.RoleChain,
+				Client:      s.Client,
+			})
+		}
+		if includeObjects {
+			for _, object := range page.Contents {
+				s3Object := newS3ObjectFromListing(*s, object)
+				if filter(s3Object) {
+					objects = append(objects, s3Object)
+				}
+			}
+		}
+	}
+
+	return childPrefixes, objects, nil
+}
@@ -0,0 +1,148 @@
+package s3utils
+
+import (
+	"github.com/tnyidea/awsutils-go/awsutils"
+	"sync"
+	"time"
+)
+
+const defaultConcurrency = 4
+
+// maxThrottledAttempts is the number of times a call throttled with SlowDown is made before its error is kept
+const maxThrottledAttempts = 5
+
+// throttleBackoff returns the delay before a throttled call is made again
+var throttleBackoff = awsutils.DefaultRetryPolicy.Backoff
+
+// forEachConcurrent calls fn for every index in [0, count) using at most concurrency goroutines, as a workerPool, and
+// then done with the error of its last attempt
+func forEachConcurrent(concurrency int, count int, fn func(i int) error, done func(i int, err error)) {
+	pool := newWorkerPool(concurrency)
+	for i := 0; i < count; i++ {
+		i := i
+		pool.submit(func() error {
+			return fn(i)
+		}, func(err error) {
+			done(i, err)
+		})
+	}
+	pool.wait()
+}
+
+// workerPool runs the calls submitted to it on at most concurrency goroutines. Submitting blocks while every worker
+// is busy, so that callers listing as they go only hold the items in flight. Each time a call returns an S3 SlowDown
+// error the number of calls in flight is halved, and it grows back by one after each run of successful calls as long
+// as the current limit. The throttled call is made again after a backoff, so bulk operations slow down instead of
+// failing the items that were throttled.
+type workerPool struct {
+	calls   chan pooledCall
+	limiter *adaptiveLimiter
+	wg      sync.WaitGroup
+}
+
+type pooledCall struct {
+	fn   func() error
+	done func(err error)
+}
+
+func newWorkerPool(concurrency int) *workerPool {
+	if concurrency < 1 {
+		concurrency = defaultConcurrency
+	}
+
+	pool := &workerPool{
+		calls:   make(chan pooledCall),
+		limiter: newAdaptiveLimiter(concurrency),
+	}
+	for w := 0; w < concurrency; w++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for call := range pool.calls {
+				var err error
+				pool.limiter.do(func(lastAttempt bool) bool {
+					err = call.fn()
+					return isSlowDown(err)
+				})
+				call.done(err)
+			}
+		}()
+	}
+	return pool
+}
+
+// submit queues fn, and done with the error of its last attempt once fn is no longer throttled or out of attempts
+func (p *workerPool) submit(fn func() error, done func(err error)) {
+	p.calls <- pooledCall{fn: fn, done: done}
+}
+
+// wait returns once every submitted call has returned. Nothing can be submitted afterwards.
+func (p *workerPool) wait() {
+	close(p.calls)
+	p.wg.Wait()
+}
+
+// adaptiveLimiter bounds the number of calls in flight with an additive increase, multiplicative decrease limit
+type adaptiveLimiter struct {
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	maxLimit  int
+	active    int
+	successes int
+}
+
+func newAdaptiveLimiter(maxLimit int) *adaptiveLimiter {
+	limiter := &adaptiveLimiter{
+		limit:    maxLimit,
+		maxLimit: maxLimit,
+	}
+	limiter.cond = sync.NewCond(&limiter.mutex)
+	return limiter
+}
+
+// do makes the call within the limit, and makes it again after a backoff each time it reports being throttled until
+// maxThrottledAttempts calls were made. The call is told whether it is the last attempt.
+func (l *adaptiveLimiter) do(call func(lastAttempt bool) (throttled bool)) {
+	for attempt := 1; ; attempt++ {
+		lastAttempt := attempt == maxThrottledAttempts
+		l.acquire()
+		throttled := call(lastAttempt)
+		l.release(throttled)
+		if !throttled || lastAttempt {
+			return
+		}
+		time.Sleep(throttleBackoff(attempt - 1))
+	}
+}
+
+func (l *adaptiveLimiter) acquire() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *adaptiveLimiter) release(throttled bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.active--
+	if throttled {
+		l.limit /= 2
+		if l.limit < 1 {
+			l.limit = 1
+		}
+		l.successes = 0
+	} else if l.limit < l.maxLimit {
+		l.successes++
+		if l.successes >= l.limit {
+			l.limit++
+			l.successes = 0
+		}
+	}
+	l.cond.Broadcast()
+}
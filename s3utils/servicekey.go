@@ -1,29 +1,25 @@
 package s3utils
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/tnyidea/awsutils-go/awsutils"
-	"strings"
 )
 
-// TODO make a version that can be passed as an encrypted hash
-func NewS3Session(serviceKey string) (*s3.S3, error) {
-	awsSession, err := awsutils.NewAWSSession(serviceKey)
-	if err != nil {
-		return nil, err
-	}
-	return s3.New(awsSession), nil
+func NewS3Session(serviceKey string) (*s3.Client, error) {
+	return NewS3SessionWithRetryPolicy(serviceKey, nil)
 }
 
-func getBucketRegion(bucket string, serviceKey string) (string, error) {
-	s3Session := session.Must(session.NewSession())
-	region, err := s3manager.GetBucketRegion(aws.BackgroundContext(), s3Session, bucket, strings.Split(serviceKey, ":")[0])
+// NewS3SessionWithRetryPolicy creates an S3 client that retries failed requests according to the policy
+func NewS3SessionWithRetryPolicy(serviceKey string, retryPolicy *awsutils.RetryPolicy) (*s3.Client, error) {
+	return NewS3SessionWithRoleChain(serviceKey, retryPolicy, nil)
+}
+
+// NewS3SessionWithRoleChain creates an S3 client with the credentials of the last role in the chain, which are
+// refreshed automatically
+func NewS3SessionWithRoleChain(serviceKey string, retryPolicy *awsutils.RetryPolicy, roleChain []awsutils.AssumeRole) (*s3.Client, error) {
+	awsConfig, err := awsutils.NewAWSConfigWithRoleChain(serviceKey, retryPolicy, roleChain)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	return region, nil
+	return s3.NewFromConfig(awsConfig), nil
 }